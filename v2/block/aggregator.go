@@ -0,0 +1,156 @@
+package block
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dusk-network/dusk-crypto/bls"
+	"github.com/dusk-network/dusk-wallet/v2/key"
+)
+
+var (
+	// ErrNotInCommittee is returned for votes of provisioners which are not
+	// part of the committee of the step
+	ErrNotInCommittee = errors.New("provisioner is not part of the committee")
+	// ErrDuplicateVote is returned for votes of members which already voted
+	// in the step
+	ErrDuplicateVote = errors.New("member already voted in this step")
+	// ErrInvalidVote is returned for votes whose signature does not match
+	// the block
+	ErrInvalidVote = errors.New("vote signature does not match the block")
+)
+
+// Vote is the vote of a committee member for a block in a step.
+type Vote struct {
+	Step uint8
+	// PubKeyBLS is the marshaled BLS public key of the member
+	PubKeyBLS []byte
+	// Signature is the compressed BLS signature of the VoteMessage
+	Signature []byte
+}
+
+// SignVote returns the vote of the provisioner with `keys` for the block
+// with header `h` in `step`.
+func SignVote(keys key.ConsensusKeys, h *Header, step uint8) (*Vote, error) {
+	sig, err := bls.Sign(keys.BLSSecretKey, keys.BLSPubKey, VoteMessage(h.Height, step, h.Hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vote{
+		Step:      step,
+		PubKeyBLS: keys.BLSPubKeyBytes,
+		Signature: sig.Compress(),
+	}, nil
+}
+
+// CommitteeAt returns the committee voting in `step`.
+type CommitteeAt func(step uint8) Committee
+
+// Aggregator collects the votes for a block, and builds its certificate
+// once two consecutive steps reached a quorum. It is safe for concurrent
+// use.
+type Aggregator struct {
+	lock        sync.Mutex
+	header      *Header
+	committeeAt CommitteeAt
+	steps       map[uint8]*stepVotes
+}
+
+type stepVotes struct {
+	committee Committee
+	bitset    uint64
+	count     int
+	sig       *bls.Signature
+}
+
+// NewAggregator returns an Aggregator for the votes for the block with
+// header `h`. The committee of each step is looked up with `committeeAt`.
+func NewAggregator(h *Header, committeeAt CommitteeAt) *Aggregator {
+	return &Aggregator{
+		header:      h,
+		committeeAt: committeeAt,
+		steps:       make(map[uint8]*stepVotes),
+	}
+}
+
+// Add checks `vote` and adds it to the votes of its step.
+func (a *Aggregator) Add(vote Vote) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	votes, ok := a.steps[vote.Step]
+	if !ok {
+		votes = &stepVotes{committee: a.committeeAt(vote.Step)}
+		if err := votes.committee.validate(); err != nil {
+			return err
+		}
+		a.steps[vote.Step] = votes
+	}
+
+	i := votes.committee.index(vote.PubKeyBLS)
+	if i < 0 {
+		return ErrNotInCommittee
+	}
+
+	if votes.bitset&(1<<uint(i)) != 0 {
+		return ErrDuplicateVote
+	}
+
+	pk, err := bls.UnmarshalPk(vote.PubKeyBLS)
+	if err != nil {
+		return err
+	}
+
+	sig, err := bls.UnmarshalSignature(vote.Signature)
+	if err != nil {
+		return err
+	}
+
+	if err := bls.Verify(bls.NewApk(pk), VoteMessage(a.header.Height, vote.Step, a.header.Hash), sig); err != nil {
+		return ErrInvalidVote
+	}
+
+	if votes.sig == nil {
+		votes.sig = sig
+	} else {
+		votes.sig = votes.sig.Aggregate(sig)
+	}
+
+	votes.bitset |= 1 << uint(i)
+	votes.count++
+	return nil
+}
+
+// Certificate returns the certificate of an agreement terminating at
+// `step`. Both `step` and the step before need a quorum of votes.
+func (a *Aggregator) Certificate(step uint8) (*Certificate, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if step == 0 {
+		return nil, ErrNoQuorum
+	}
+
+	stepOne, ok := a.steps[step-1]
+	if !ok || !stepOne.hasQuorum() {
+		return nil, ErrNoQuorum
+	}
+
+	stepTwo, ok := a.steps[step]
+	if !ok || !stepTwo.hasQuorum() {
+		return nil, ErrNoQuorum
+	}
+
+	return &Certificate{
+		StepOneBatchedSig: stepOne.sig.Compress(),
+		StepTwoBatchedSig: stepTwo.sig.Compress(),
+		Step:              step,
+		StepOneCommittee:  stepOne.bitset,
+		StepTwoCommittee:  stepTwo.bitset,
+	}, nil
+}
+
+func (v *stepVotes) hasQuorum() bool {
+	return v.count > 0 && v.count >= Quorum(len(v.committee))
+}
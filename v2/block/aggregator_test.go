@@ -0,0 +1,96 @@
+package block
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/dusk-network/dusk-wallet/v2/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateAggregator(t *testing.T) {
+	// Each step is voted by its own committee of 8 provisioners
+	committees := make(map[uint8]Committee)
+	keys := make(map[uint8][]key.ConsensusKeys)
+	for step := uint8(1); step <= 3; step++ {
+		committees[step], keys[step] = generateCommittee(t, 8)
+	}
+	committeeAt := func(step uint8) Committee {
+		return committees[step]
+	}
+
+	blk := NewBlock()
+	blk.Header.Height = 20
+	seal(t, blk)
+	aggregator := NewAggregator(blk.Header, committeeAt)
+
+	// Five members vote in the first step, all of them concurrently in
+	// the second one
+	for i := 0; i < 5; i++ {
+		vote, err := SignVote(keys[1][i], blk.Header, 1)
+		assert.NoError(t, err)
+		assert.NoError(t, aggregator.Add(*vote))
+	}
+
+	var wg sync.WaitGroup
+	for i := range keys[2] {
+		wg.Add(1)
+		go func(keys key.ConsensusKeys) {
+			defer wg.Done()
+			vote, err := SignVote(keys, blk.Header, 2)
+			assert.NoError(t, err)
+			assert.NoError(t, aggregator.Add(*vote))
+		}(keys[2][i])
+	}
+	wg.Wait()
+
+	// Five of eight votes are no quorum
+	_, err := aggregator.Certificate(2)
+	assert.Equal(t, ErrNoQuorum, err)
+
+	vote, err := SignVote(keys[1][7], blk.Header, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, aggregator.Add(*vote))
+	assert.Equal(t, ErrDuplicateVote, aggregator.Add(*vote))
+
+	// Members of other committees, and votes for other blocks are rejected
+	vote, err = SignVote(keys[3][0], blk.Header, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrNotInCommittee, aggregator.Add(*vote))
+
+	other := *blk.Header
+	other.Hash = make([]byte, 32)
+	vote, err = SignVote(keys[1][6], &other, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrInvalidVote, aggregator.Add(*vote))
+
+	// Committees holding a provisioner twice are rejected
+	duplicate := NewAggregator(blk.Header, func(step uint8) Committee {
+		return Committee{committees[1][0], committees[1][0]}
+	})
+	vote, err = SignVote(keys[1][0], blk.Header, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrDuplicateMember, duplicate.Add(*vote))
+
+	cert, err := aggregator.Certificate(2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(2), cert.Step)
+	assert.Equal(t, uint64(0x9f), cert.StepOneCommittee)
+	assert.Equal(t, uint64(0xff), cert.StepTwoCommittee)
+
+	// The certificate survives the wire, and verifies against the
+	// committees
+	blk.Header.Certificate = cert
+	buf := new(bytes.Buffer)
+	assert.NoError(t, MarshalCertificate(buf, cert))
+	decoded := &Certificate{}
+	assert.NoError(t, UnmarshalCertificate(buf, decoded))
+	assert.True(t, cert.Equals(decoded))
+	assert.NoError(t, blk.Header.VerifyCertificate(committees[1], committees[2]))
+	assert.Equal(t, ErrInvalidCertificate, blk.Header.VerifyCertificate(committees[2], committees[2]))
+
+	// No votes were cast in the third step
+	_, err = aggregator.Certificate(3)
+	assert.Equal(t, ErrNoQuorum, err)
+}
@@ -0,0 +1,197 @@
+package block
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+)
+
+// MaxBlockSize is the default size limit of an encoded block in bytes
+const MaxBlockSize = 1 << 20
+
+// headerSize is the size of an encoded header in bytes
+const headerSize = 1 + 8 + 8 + HeaderHashSize + BLSSize + HeaderHashSize +
+	2*BLSSize + 1 + 8 + 8 + HeaderHashSize
+
+var (
+	// ErrBlockTooLarge is returned when the coinbase alone exceeds the size
+	// limit of the block
+	ErrBlockTooLarge = errors.New("coinbase does not fit into the block")
+	// ErrUnexpectedCoinbase is returned when the txs to add to a block hold
+	// a coinbase, which can only be its first tx
+	ErrUnexpectedCoinbase = errors.New("coinbase can not be added as a tx")
+	// ErrInvalidTimestamp is returned when the clock is not past the
+	// timestamp of the previous block
+	ErrInvalidTimestamp = errors.New("block timestamp is not after the previous block")
+)
+
+// Builder assembles the blocks of a block generator.
+type Builder struct {
+	// MaxSize is the size limit of the encoded block
+	MaxSize int
+
+	now func() time.Time
+}
+
+// NewBuilder returns a Builder for blocks of up to MaxBlockSize bytes.
+func NewBuilder() *Builder {
+	return &Builder{
+		MaxSize: MaxBlockSize,
+		now:     time.Now,
+	}
+}
+
+type candidate struct {
+	tx   transactions.Transaction
+	txID []byte
+	size int
+	fee  uint64
+}
+
+// Build returns the block following `prev`, with `coinbase` as its first
+// tx. Out of `txs`, the ones paying the highest fee per byte are added,
+// as long as the block stays within MaxSize. Txs spending a key image
+// already spent by a tx with a higher fee are left out. The block has to be
+// younger than `prev`, and is verified against its header, and against
+// `prev`, before it is returned.
+func (b *Builder) Build(coinbase *transactions.Coinbase, txs []transactions.Transaction, prev *Header, seed []byte) (*Block, error) {
+	if len(seed) != BLSSize {
+		return nil, fmt.Errorf("seed has %d bytes instead of %d", len(seed), BLSSize)
+	}
+
+	if prev == nil {
+		return nil, errors.New("previous block header is missing")
+	}
+
+	if len(prev.Hash) != HeaderHashSize {
+		return nil, fmt.Errorf("previous block hash has %d bytes instead of %d", len(prev.Hash), HeaderHashSize)
+	}
+
+	timestamp := b.now().Unix()
+	if timestamp <= prev.Timestamp {
+		return nil, ErrInvalidTimestamp
+	}
+
+	coinbaseSize, err := encodedSize(coinbase)
+	if err != nil {
+		return nil, err
+	}
+
+	// The tx count takes up to 9 bytes
+	size := headerSize + 9 + coinbaseSize
+	if size > b.MaxSize {
+		return nil, ErrBlockTooLarge
+	}
+
+	candidates, err := orderTxs(txs)
+	if err != nil {
+		return nil, err
+	}
+
+	blk := NewBlock()
+	blk.AddTx(coinbase)
+
+	spent := make(map[string]struct{})
+	for _, c := range candidates {
+		if size+c.size > b.MaxSize || spendsAny(c.tx, spent) {
+			continue
+		}
+
+		for _, input := range c.tx.StandardTx().Inputs {
+			spent[string(input.KeyImage.Bytes())] = struct{}{}
+		}
+
+		blk.AddTx(c.tx)
+		size += c.size
+	}
+
+	blk.Header.Version = prev.Version
+	blk.Header.Height = prev.Height + 1
+	blk.Header.Timestamp = timestamp
+	blk.Header.Seed = seed
+	blk.SetPrevBlock(prev)
+
+	if blk.Header.TxRoot, err = blk.CalculateRoot(); err != nil {
+		return nil, err
+	}
+
+	if blk.Header.Hash, err = blk.CalculateHash(); err != nil {
+		return nil, err
+	}
+
+	if err := blk.Verify(); err != nil {
+		return nil, err
+	}
+
+	if err := blk.Follows(prev.Hash); err != nil {
+		return nil, err
+	}
+
+	return blk, nil
+}
+
+// orderTxs sorts `txs` by their fee per byte, highest first. Txs with the
+// same fee per byte are ordered by their id, so that the order does not
+// depend on the order of the mempool.
+func orderTxs(txs []transactions.Transaction) ([]candidate, error) {
+	candidates := make([]candidate, len(txs))
+	for i, tx := range txs {
+		if tx.Type() == transactions.CoinbaseType {
+			return nil, ErrUnexpectedCoinbase
+		}
+
+		txID, err := tx.CalculateHash()
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := encodedSize(tx)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates[i] = candidate{
+			tx:   tx,
+			txID: txID,
+			size: size,
+			fee:  tx.StandardTx().Fee.BigInt().Uint64(),
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		// Compare fee_i / size_i with fee_j / size_j without rounding
+		a := new(big.Int).Mul(new(big.Int).SetUint64(candidates[i].fee), big.NewInt(int64(candidates[j].size)))
+		b := new(big.Int).Mul(new(big.Int).SetUint64(candidates[j].fee), big.NewInt(int64(candidates[i].size)))
+		if cmp := a.Cmp(b); cmp != 0 {
+			return cmp > 0
+		}
+
+		return bytes.Compare(candidates[i].txID, candidates[j].txID) < 0
+	})
+
+	return candidates, nil
+}
+
+func spendsAny(tx transactions.Transaction, spent map[string]struct{}) bool {
+	for _, input := range tx.StandardTx().Inputs {
+		if _, ok := spent[string(input.KeyImage.Bytes())]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func encodedSize(tx transactions.Transaction) (int, error) {
+	buf := new(bytes.Buffer)
+	if err := transactions.Marshal(buf, tx); err != nil {
+		return 0, err
+	}
+
+	return buf.Len(), nil
+}
@@ -0,0 +1,88 @@
+package block
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockBuilder(t *testing.T) {
+	prev := NewBlock()
+	prev.Header.Height = 41
+	prev.Header.PrevBlockHash = make([]byte, HeaderHashSize)
+	prev.Header.Seed = make([]byte, BLSSize)
+	prev.Header.Timestamp = 1000
+	seal(t, prev)
+
+	coinbase := randomCoinbase(t)
+
+	// The mempool holds txs paying different fees, and a double spend
+	var mempool []transactions.Transaction
+	for _, fee := range []int64{100, 0, 300} {
+		mempool = append(mempool, randomTx(t, 0, fee))
+	}
+
+	doubleSpend := *mempool[2].(*transactions.Standard)
+	doubleSpend.Fee.SetBigInt(big.NewInt(10))
+	mempool = append(mempool, &doubleSpend)
+
+	seed := make([]byte, BLSSize)
+	seed[0] = 7
+	builder := NewBuilder()
+	blk, err := builder.Build(coinbase, mempool, prev.Header, seed)
+	assert.NoError(t, err)
+
+	// The coinbase comes first, followed by the txs with the highest fees
+	assert.Equal(t, 4, len(blk.Txs))
+	assert.True(t, blk.Txs[0].Equals(coinbase))
+	assert.True(t, blk.Txs[1].Equals(mempool[2]))
+	assert.True(t, blk.Txs[2].Equals(mempool[0]))
+	assert.True(t, blk.Txs[3].Equals(mempool[1]))
+
+	assert.Equal(t, uint64(42), blk.Header.Height)
+	assert.Equal(t, seed, blk.Header.Seed)
+	assert.NoError(t, blk.Verify())
+	assert.NoError(t, blk.Follows(prev.Header.Hash))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Marshal(buf, blk))
+
+	// Lowering the size limit leaves out the tx with the lowest fee
+	builder.MaxSize = buf.Len() - 1
+	blk, err = builder.Build(coinbase, mempool, prev.Header, seed)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(blk.Txs))
+	assert.True(t, blk.Txs[2].Equals(mempool[0]))
+	assert.NoError(t, blk.Verify())
+
+	builder.MaxSize = 100
+	_, err = builder.Build(coinbase, mempool, prev.Header, seed)
+	assert.Equal(t, ErrBlockTooLarge, err)
+
+	_, err = builder.Build(coinbase, mempool, prev.Header, seed[1:])
+	assert.Error(t, err)
+
+	// Coinbases can not be added as txs, and the previous block is needed
+	builder.MaxSize = MaxBlockSize
+	_, err = builder.Build(coinbase, append(mempool, randomCoinbase(t)), prev.Header, seed)
+	assert.Equal(t, ErrUnexpectedCoinbase, err)
+
+	_, err = builder.Build(coinbase, mempool, nil, seed)
+	assert.Error(t, err)
+
+	// The block has to be younger than the previous one
+	for _, timestamp := range []int64{999, 1000} {
+		builder.now = func() time.Time { return time.Unix(timestamp, 0) }
+		_, err = builder.Build(coinbase, mempool, prev.Header, seed)
+		assert.Equal(t, ErrInvalidTimestamp, err)
+	}
+
+	builder.now = func() time.Time { return time.Unix(1001, 0) }
+	blk, err = builder.Build(coinbase, mempool, prev.Header, seed)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1001), blk.Header.Timestamp)
+}
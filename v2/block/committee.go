@@ -0,0 +1,156 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dusk-network/dusk-crypto/bls"
+)
+
+// MaxCommitteeSize is the amount of members a committee bitset can hold
+const MaxCommitteeSize = 64
+
+var (
+	// ErrInvalidCertificate is returned for certificates whose signatures
+	// do not match the committee
+	ErrInvalidCertificate = errors.New("certificate signature does not match the committee")
+	// ErrNoQuorum is returned for certificates which were not signed by
+	// enough committee members
+	ErrNoQuorum = errors.New("certificate was not signed by a quorum of the committee")
+	// ErrDuplicateMember is returned for committees which hold a
+	// provisioner more than once
+	ErrDuplicateMember = errors.New("committee holds a provisioner more than once")
+)
+
+// Committee holds the marshaled BLS public keys of the provisioners voting
+// in a step, in the order used for the certificate bitsets: the lowest bit
+// stands for the first member. Every provisioner has a single seat, so a
+// committee can not hold the same key twice.
+type Committee [][]byte
+
+// Quorum returns the amount of votes needed for a committee of `size`
+// members to reach agreement.
+func Quorum(size int) int {
+	return (size*3 + 3) / 4
+}
+
+// VoteMessage returns the message signed by the committee members voting
+// for the block with hash `blockHash` at `height` and `step`: the height as
+// a little endian uint64, followed by the step and the block hash.
+func VoteMessage(height uint64, step uint8, blockHash []byte) []byte {
+	buf := new(bytes.Buffer)
+	_ = binary.Write(buf, binary.LittleEndian, height)
+	_ = binary.Write(buf, binary.LittleEndian, step)
+	_, _ = buf.Write(blockHash)
+	return buf.Bytes()
+}
+
+// VerifyCertificate checks the certificate of `h` against the committees
+// of its last two steps. The first batched signature is made in the step
+// before the one the agreement terminated at, by `stepOne`, and the second
+// in that step, by `stepTwo`.
+func (h *Header) VerifyCertificate(stepOne, stepTwo Committee) error {
+	c := h.Certificate
+	if c == nil || c.Step == 0 {
+		return ErrInvalidCertificate
+	}
+
+	if err := verifyStep(h, c.Step-1, c.StepOneBatchedSig, c.StepOneCommittee, stepOne); err != nil {
+		return err
+	}
+
+	return verifyStep(h, c.Step, c.StepTwoBatchedSig, c.StepTwoCommittee, stepTwo)
+}
+
+func verifyStep(h *Header, step uint8, batchedSig []byte, bitset uint64, committee Committee) error {
+	members, err := committee.Intersect(bitset)
+	if err != nil {
+		return err
+	}
+
+	if len(members) < Quorum(len(committee)) || len(members) == 0 {
+		return ErrNoQuorum
+	}
+
+	apk, err := aggregateKeys(members)
+	if err != nil {
+		return err
+	}
+
+	sig, err := bls.UnmarshalSignature(batchedSig)
+	if err != nil {
+		return err
+	}
+
+	if err := bls.Verify(apk, VoteMessage(h.Height, step, h.Hash), sig); err != nil {
+		return ErrInvalidCertificate
+	}
+
+	return nil
+}
+
+// Intersect returns the members of the committee selected by `bitset`.
+func (c Committee) Intersect(bitset uint64) (Committee, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(c) < MaxCommitteeSize && bitset>>uint(len(c)) != 0 {
+		return nil, fmt.Errorf("bitset %x selects members outside of the committee", bitset)
+	}
+
+	var members Committee
+	for i, pubKey := range c {
+		if bitset&(1<<uint(i)) != 0 {
+			members = append(members, pubKey)
+		}
+	}
+
+	return members, nil
+}
+
+// validate checks that the committee fits in a bitset, and holds every
+// provisioner once.
+func (c Committee) validate() error {
+	if len(c) > MaxCommitteeSize {
+		return fmt.Errorf("committee has %d members, the maximum is %d", len(c), MaxCommitteeSize)
+	}
+
+	seen := make(map[string]bool, len(c))
+	for _, member := range c {
+		if seen[string(member)] {
+			return ErrDuplicateMember
+		}
+		seen[string(member)] = true
+	}
+
+	return nil
+}
+
+func (c Committee) index(pubKey []byte) int {
+	for i, member := range c {
+		if bytes.Equal(member, pubKey) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func aggregateKeys(members Committee) (*bls.Apk, error) {
+	first, err := bls.UnmarshalPk(members[0])
+	if err != nil {
+		return nil, err
+	}
+
+	apk := bls.NewApk(first)
+	for _, member := range members[1:] {
+		if err := apk.AggregateBytes(member); err != nil {
+			return nil, err
+		}
+	}
+
+	return apk, nil
+}
@@ -0,0 +1,97 @@
+package block
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/dusk-network/dusk-crypto/bls"
+	"github.com/dusk-network/dusk-wallet/v2/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateVerification(t *testing.T) {
+	committee, keys := generateCommittee(t, 4)
+	blk := NewBlock()
+	blk.Header.Height = 10
+	seal(t, blk)
+
+	// Three of four members agree in the first step, and all of them in
+	// the second one
+	c := blk.Header.Certificate
+	c.Step = 3
+	c.StepOneBatchedSig, c.StepOneCommittee = signStep(t, blk.Header, 2, keys, 0, 1, 3)
+	c.StepTwoBatchedSig, c.StepTwoCommittee = signStep(t, blk.Header, 3, keys, 0, 1, 2, 3)
+	assert.Equal(t, uint64(0xb), c.StepOneCommittee)
+	assert.NoError(t, blk.Header.VerifyCertificate(committee, committee))
+
+	// The signatures do not hold for another committee
+	other, _ := generateCommittee(t, 4)
+	assert.Equal(t, ErrInvalidCertificate, blk.Header.VerifyCertificate(committee, other))
+
+	// Claiming a member voted which did not sign invalidates the
+	// signature
+	c.StepOneCommittee = 0xf
+	assert.Equal(t, ErrInvalidCertificate, blk.Header.VerifyCertificate(committee, committee))
+
+	// Bits outside of the committee are rejected
+	c.StepOneCommittee = 0x1b
+	assert.Error(t, blk.Header.VerifyCertificate(committee, committee))
+
+	// Two of four votes are no quorum
+	c.StepOneBatchedSig, c.StepOneCommittee = signStep(t, blk.Header, 2, keys, 0, 1)
+	assert.Equal(t, ErrNoQuorum, blk.Header.VerifyCertificate(committee, committee))
+
+	// Votes are bound to their step
+	c.StepOneBatchedSig, c.StepOneCommittee = signStep(t, blk.Header, 3, keys, 0, 1, 2)
+	assert.Equal(t, ErrInvalidCertificate, blk.Header.VerifyCertificate(committee, committee))
+
+	// A provisioner can not hold more than one seat
+	c.StepOneBatchedSig, c.StepOneCommittee = signStep(t, blk.Header, 2, keys, 0, 1, 3)
+	duplicate := append(Committee{}, committee...)
+	duplicate[2] = duplicate[0]
+	assert.Equal(t, ErrDuplicateMember, blk.Header.VerifyCertificate(duplicate, committee))
+	assert.NoError(t, blk.Header.VerifyCertificate(committee, committee))
+}
+
+func TestQuorum(t *testing.T) {
+	for size, quorum := range map[int]int{1: 1, 3: 3, 4: 3, 8: 6, 64: 48} {
+		assert.Equal(t, quorum, Quorum(size))
+	}
+}
+
+// generateCommittee simulates a committee of `size` provisioners, and
+// returns it along with their keys.
+func generateCommittee(t *testing.T, size int) (Committee, []key.ConsensusKeys) {
+	committee := make(Committee, size)
+	keys := make([]key.ConsensusKeys, size)
+	for i := range committee {
+		var err error
+		keys[i], err = key.NewConsensusKeysFromReader(rand.New(rand.NewSource(rand.Int63())))
+		assert.NoError(t, err)
+		committee[i] = keys[i].BLSPubKeyBytes
+	}
+
+	return committee, keys
+}
+
+// signStep returns the batched signature of the committee `members` voting
+// for `header` in `step`, along with their bitset.
+func signStep(t *testing.T, header *Header, step uint8, keys []key.ConsensusKeys, members ...int) ([]byte, uint64) {
+	var batched *bls.Signature
+	var bitset uint64
+	for _, i := range members {
+		vote, err := SignVote(keys[i], header, step)
+		assert.NoError(t, err)
+		sig, err := bls.UnmarshalSignature(vote.Signature)
+		assert.NoError(t, err)
+
+		if batched == nil {
+			batched = sig
+		} else {
+			batched = batched.Aggregate(sig)
+		}
+		bitset |= 1 << uint(i)
+	}
+
+	return batched.Compress(), bitset
+}
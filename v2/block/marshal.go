@@ -0,0 +1,219 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+)
+
+// BLSSize is the size of a compressed BLS signature in bytes
+const BLSSize = 33
+
+// maxTxs bounds the amount of txs read from a single block, so that a
+// corrupt length can not exhaust memory
+const maxTxs = 1 << 16
+
+// Marshal writes `blk` to `b` in the block file format of the wallet: the
+// header as written by MarshalHeader, followed by the tx count as a VarInt
+// and the txs as written by transactions.Marshal.
+func Marshal(b *bytes.Buffer, blk *Block) error {
+	if err := MarshalHeader(b, blk.Header); err != nil {
+		return err
+	}
+
+	if err := transactions.WriteVarInt(b, uint64(len(blk.Txs))); err != nil {
+		return err
+	}
+
+	for _, tx := range blk.Txs {
+		if err := transactions.Marshal(b, tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Unmarshal reads a block written by Marshal from `b` into `blk`.
+func Unmarshal(b *bytes.Buffer, blk *Block) error {
+	if blk.Header == nil {
+		blk.Header = NewHeader()
+	}
+
+	if err := UnmarshalHeader(b, blk.Header); err != nil {
+		return err
+	}
+
+	lenTxs, err := transactions.ReadVarInt(b)
+	if err != nil {
+		return err
+	}
+
+	if lenTxs > maxTxs {
+		return fmt.Errorf("block has %d txs, the maximum is %d", lenTxs, maxTxs)
+	}
+
+	blk.Txs = make([]transactions.Transaction, lenTxs)
+	for i := range blk.Txs {
+		if blk.Txs[i], err = transactions.Unmarshal(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarshalHeader writes `h` to `b`. Unlike the encoding used for the header
+// hash, it includes the tx root, the certificate and the hash itself:
+//
+//	version (1) | height (8) | timestamp (8) | previous block hash (32) |
+//	seed (33) | tx root (32) | certificate | hash (32)
+//
+// Integers are little endian.
+func MarshalHeader(b *bytes.Buffer, h *Header) error {
+	if err := binary.Write(b, binary.LittleEndian, h.Version); err != nil {
+		return err
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, h.Height); err != nil {
+		return err
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, uint64(h.Timestamp)); err != nil {
+		return err
+	}
+
+	if err := writeFixed(b, h.PrevBlockHash, HeaderHashSize, "previous block hash"); err != nil {
+		return err
+	}
+
+	if err := writeFixed(b, h.Seed, BLSSize, "seed"); err != nil {
+		return err
+	}
+
+	if err := writeFixed(b, h.TxRoot, HeaderHashSize, "tx root"); err != nil {
+		return err
+	}
+
+	if err := MarshalCertificate(b, h.Certificate); err != nil {
+		return err
+	}
+
+	return writeFixed(b, h.Hash, HeaderHashSize, "block hash")
+}
+
+// UnmarshalHeader reads a header written by MarshalHeader from `b` into `h`.
+func UnmarshalHeader(b *bytes.Buffer, h *Header) error {
+	if err := binary.Read(b, binary.LittleEndian, &h.Version); err != nil {
+		return err
+	}
+
+	if err := binary.Read(b, binary.LittleEndian, &h.Height); err != nil {
+		return err
+	}
+
+	var timestamp uint64
+	if err := binary.Read(b, binary.LittleEndian, &timestamp); err != nil {
+		return err
+	}
+	h.Timestamp = int64(timestamp)
+
+	var err error
+	if h.PrevBlockHash, err = readFixed(b, HeaderHashSize); err != nil {
+		return err
+	}
+
+	if h.Seed, err = readFixed(b, BLSSize); err != nil {
+		return err
+	}
+
+	if h.TxRoot, err = readFixed(b, HeaderHashSize); err != nil {
+		return err
+	}
+
+	if h.Certificate == nil {
+		h.Certificate = &Certificate{}
+	}
+
+	if err := UnmarshalCertificate(b, h.Certificate); err != nil {
+		return err
+	}
+
+	h.Hash, err = readFixed(b, HeaderHashSize)
+	return err
+}
+
+// MarshalCertificate writes `c` to `b`:
+//
+//	step one signature (33) | step two signature (33) | step (1) |
+//	step one committee (8) | step two committee (8)
+func MarshalCertificate(b *bytes.Buffer, c *Certificate) error {
+	if c == nil {
+		return errors.New("header has no certificate")
+	}
+
+	if err := writeFixed(b, c.StepOneBatchedSig, BLSSize, "step one signature"); err != nil {
+		return err
+	}
+
+	if err := writeFixed(b, c.StepTwoBatchedSig, BLSSize, "step two signature"); err != nil {
+		return err
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, c.Step); err != nil {
+		return err
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, c.StepOneCommittee); err != nil {
+		return err
+	}
+
+	return binary.Write(b, binary.LittleEndian, c.StepTwoCommittee)
+}
+
+// UnmarshalCertificate reads a certificate written by MarshalCertificate
+// from `b` into `c`.
+func UnmarshalCertificate(b *bytes.Buffer, c *Certificate) error {
+	var err error
+	if c.StepOneBatchedSig, err = readFixed(b, BLSSize); err != nil {
+		return err
+	}
+
+	if c.StepTwoBatchedSig, err = readFixed(b, BLSSize); err != nil {
+		return err
+	}
+
+	if err := binary.Read(b, binary.LittleEndian, &c.Step); err != nil {
+		return err
+	}
+
+	if err := binary.Read(b, binary.LittleEndian, &c.StepOneCommittee); err != nil {
+		return err
+	}
+
+	return binary.Read(b, binary.LittleEndian, &c.StepTwoCommittee)
+}
+
+// writeFixed writes a field of a fixed size. It refuses to pad or truncate
+// fields of the wrong size.
+func writeFixed(b *bytes.Buffer, bs []byte, size int, name string) error {
+	if len(bs) != size {
+		return fmt.Errorf("%s has %d bytes instead of %d", name, len(bs), size)
+	}
+
+	_, err := b.Write(bs)
+	return err
+}
+
+func readFixed(b *bytes.Buffer, size int) ([]byte, error) {
+	bs := make([]byte, size)
+	if _, err := io.ReadFull(b, bs); err != nil {
+		return nil, err
+	}
+
+	return bs, nil
+}
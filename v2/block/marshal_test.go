@@ -0,0 +1,147 @@
+package block
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/bwesterb/go-ristretto"
+	"github.com/dusk-network/dusk-crypto/mlsag"
+	"github.com/dusk-network/dusk-wallet/v2/key"
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshal(t *testing.T) {
+	blk := NewBlock()
+	blk.Header.Version = 1
+	blk.Header.Height = 12
+	blk.Header.Timestamp = 1000
+	blk.Header.PrevBlockHash = randomSlice(HeaderHashSize)
+	blk.Header.Seed = randomSlice(BLSSize)
+	blk.Header.Certificate = &Certificate{
+		StepOneBatchedSig: randomSlice(BLSSize),
+		StepTwoBatchedSig: randomSlice(BLSSize),
+		Step:              3,
+		StepOneCommittee:  0x0f,
+		StepTwoCommittee:  0x1e,
+	}
+	blk.AddTx(randomCoinbase(t))
+	blk.AddTx(randomTx(t, 0, 100))
+	blk.AddTx(randomTx(t, transactions.ViewTagVersion, 200))
+	seal(t, blk)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Marshal(buf, blk))
+	encoded := append([]byte{}, buf.Bytes()...)
+
+	decoded := NewBlock()
+	assert.NoError(t, Unmarshal(buf, decoded))
+	assert.Equal(t, 0, buf.Len())
+
+	// Decoded blocks have the same header, still verify, and encode to the
+	// same bytes
+	assert.True(t, blk.Header.Equals(decoded.Header))
+	assert.NoError(t, decoded.Verify())
+	assert.Equal(t, len(blk.Txs), len(decoded.Txs))
+
+	reencoded := new(bytes.Buffer)
+	assert.NoError(t, Marshal(reencoded, decoded))
+	assert.Equal(t, encoded, reencoded.Bytes())
+
+	// The header comes first, followed by the tx count
+	assert.Equal(t, uint8(len(blk.Txs)), encoded[headerSize])
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	blk := NewBlock()
+	blk.Header.PrevBlockHash = randomSlice(HeaderHashSize)
+	blk.Header.Seed = randomSlice(BLSSize)
+	blk.AddTx(randomCoinbase(t))
+	seal(t, blk)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Marshal(buf, blk))
+
+	// Truncated blocks can not be decoded
+	assert.Error(t, Unmarshal(bytes.NewBuffer(buf.Bytes()[:buf.Len()-1]), NewBlock()))
+
+	// Neither can blocks claiming more txs than allowed
+	header := new(bytes.Buffer)
+	assert.NoError(t, MarshalHeader(header, blk.Header))
+	assert.NoError(t, transactions.WriteVarInt(header, maxTxs+1))
+	assert.Error(t, Unmarshal(header, NewBlock()))
+
+	// Fields of the wrong size can not be encoded
+	blk.Header.Seed = nil
+	assert.Error(t, Marshal(new(bytes.Buffer), blk))
+
+	blk.Header.Seed = randomSlice(BLSSize)
+	blk.Header.Certificate = nil
+	assert.Error(t, Marshal(new(bytes.Buffer), blk))
+}
+
+// seal fills in the tx root and hash of `blk`.
+func seal(t *testing.T, blk *Block) *Block {
+	if len(blk.Txs) > 0 {
+		root, err := blk.CalculateRoot()
+		assert.NoError(t, err)
+		blk.Header.TxRoot = root
+	}
+
+	hash, err := blk.CalculateHash()
+	assert.NoError(t, err)
+	blk.Header.Hash = hash
+	return blk
+}
+
+// randomTx returns a signed standard tx of `version`, paying `fee`, which
+// spends a random input.
+func randomTx(t *testing.T, version uint8, fee int64) *transactions.Standard {
+	tx, err := transactions.NewStandard(version, 1, fee)
+	assert.NoError(t, err)
+
+	var amount, mask, privKey ristretto.Scalar
+	amount.SetBigInt(big.NewInt(1000))
+	mask.Rand()
+	privKey.Rand()
+	assert.NoError(t, tx.AddInput(transactions.NewInput(amount, mask, privKey)))
+	assert.NoError(t, tx.AddDecoys(7, randomDecoys))
+
+	addr, err := key.NewKeyPair(randomSlice(32)).PublicKey().PublicAddress(1)
+	assert.NoError(t, err)
+	amount.SetBigInt(big.NewInt(1000 - fee))
+	assert.NoError(t, tx.AddOutput(*addr, amount))
+
+	assert.NoError(t, tx.Prove())
+	return tx
+}
+
+func randomCoinbase(t *testing.T) *transactions.Coinbase {
+	coinbase := transactions.NewCoinbase(randomSlice(100), randomSlice(32), 1)
+
+	var reward ristretto.Scalar
+	reward.SetBigInt(big.NewInt(1000))
+	assert.NoError(t, coinbase.AddReward(*key.NewKeyPair(randomSlice(32)).PublicKey(), reward))
+	return coinbase
+}
+
+func randomDecoys(numMixins int) []mlsag.PubKeys {
+	decoys := make([]mlsag.PubKeys, numMixins)
+	for i := range decoys {
+		for j := 0; j < 2; j++ {
+			var p ristretto.Point
+			p.Rand()
+			decoys[i].AddPubKey(p)
+		}
+	}
+
+	return decoys
+}
+
+func randomSlice(n int) []byte {
+	slice := make([]byte, n)
+	rand.Read(slice)
+	return slice
+}
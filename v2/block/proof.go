@@ -0,0 +1,127 @@
+package block
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/dusk-network/dusk-crypto/hash"
+	"github.com/dusk-network/dusk-crypto/merkletree"
+)
+
+var (
+	// ErrTxNotInBlock is returned when proving the inclusion of a tx which
+	// is not part of the block
+	ErrTxNotInBlock = errors.New("tx is not included in the block")
+	// ErrInvalidProof is returned for inclusion proofs which do not lead to
+	// the tx root
+	ErrInvalidProof = errors.New("inclusion proof does not match the tx root")
+)
+
+// InclusionProof proves that a tx is part of the merkle tree of a block,
+// without the other txs of the block.
+type InclusionProof struct {
+	// Index is the position of the tx in the block
+	Index uint32
+	// Siblings holds the hashes of the sibling nodes on the path from the
+	// tx up to the root
+	Siblings [][]byte
+}
+
+// ProveInclusion returns the inclusion proof of the tx with hash `txID`.
+func (b *Block) ProveInclusion(txID []byte) (*InclusionProof, error) {
+	proofs, err := b.ProveInclusions([][]byte{txID})
+	if err != nil {
+		return nil, err
+	}
+
+	return proofs[0], nil
+}
+
+// ProveInclusions returns the inclusion proofs of the txs with hashes
+// `txIDs`, in the same order. The merkle tree of the block is built once for
+// all of them.
+func (b *Block) ProveInclusions(txIDs [][]byte) ([]*InclusionProof, error) {
+	if len(txIDs) == 0 {
+		return nil, nil
+	}
+
+	var txs []merkletree.Payload
+	for _, tx := range b.Txs {
+		txs = append(txs, tx)
+	}
+
+	if len(txs) == 0 {
+		return nil, ErrTxNotInBlock
+	}
+
+	tree, err := merkletree.NewTree(txs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Txs are identified by their hash, so the first leaf holding it is
+	// the one of the tx
+	index := make(map[string]int, len(tree.Leaves))
+	for i := len(tree.Leaves) - 1; i >= 0; i-- {
+		index[string(tree.Leaves[i].Hash)] = i
+	}
+
+	proofs := make([]*InclusionProof, len(txIDs))
+	for i, txID := range txIDs {
+		leaf, ok := index[string(txID)]
+		if !ok {
+			return nil, ErrTxNotInBlock
+		}
+
+		proofs[i] = proveLeaf(tree.Leaves[leaf], uint32(leaf))
+	}
+
+	return proofs, nil
+}
+
+func proveLeaf(leaf *merkletree.Node, index uint32) *InclusionProof {
+	proof := &InclusionProof{Index: index}
+	for node := leaf; node.Parent != nil; node = node.Parent {
+		// An odd node is paired with itself, so comparing with the left
+		// child first picks the right sibling for it
+		sibling := node.Parent.Left
+		if sibling == node {
+			sibling = node.Parent.Right
+		}
+		proof.Siblings = append(proof.Siblings, sibling.Hash)
+	}
+
+	return proof
+}
+
+// Verify checks that the tx with hash `txID` is part of the merkle tree
+// with root `txRoot`.
+func (p *InclusionProof) Verify(txID, txRoot []byte) error {
+	if len(p.Siblings) > 32 {
+		return ErrInvalidProof
+	}
+
+	current := txID
+	index := p.Index
+	for _, sibling := range p.Siblings {
+		var data []byte
+		if index%2 == 0 {
+			data = append(append(data, current...), sibling...)
+		} else {
+			data = append(append(data, sibling...), current...)
+		}
+
+		var err error
+		if current, err = hash.Sha3256(data); err != nil {
+			return err
+		}
+
+		index /= 2
+	}
+
+	if index != 0 || !bytes.Equal(current, txRoot) {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
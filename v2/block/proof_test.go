@@ -0,0 +1,89 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInclusionProof(t *testing.T) {
+	// Five txs, so that the last one is paired with itself
+	blk := NewBlock()
+	blk.AddTx(randomCoinbase(t))
+	for i := 0; i < 4; i++ {
+		blk.AddTx(randomTx(t, 0, 100))
+	}
+	seal(t, blk)
+
+	otherID, err := blk.Txs[0].CalculateHash()
+	assert.NoError(t, err)
+
+	for i, tx := range blk.Txs {
+		txID, err := tx.CalculateHash()
+		assert.NoError(t, err)
+
+		proof, err := blk.ProveInclusion(txID)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(i), proof.Index)
+		assert.NoError(t, proof.Verify(txID, blk.Header.TxRoot))
+
+		// The proof does not hold for other roots
+		assert.Equal(t, ErrInvalidProof, proof.Verify(txID, otherID))
+
+		// Nor for other txs
+		if i > 0 {
+			assert.Equal(t, ErrInvalidProof, proof.Verify(otherID, blk.Header.TxRoot))
+		}
+	}
+
+	// The last tx is paired with itself, so only the third one has a
+	// sibling to swap places with
+	txID, err := blk.Txs[2].CalculateHash()
+	assert.NoError(t, err)
+	proof, err := blk.ProveInclusion(txID)
+	assert.NoError(t, err)
+	proof.Index ^= 1
+	assert.Equal(t, ErrInvalidProof, proof.Verify(txID, blk.Header.TxRoot))
+
+	_, err = blk.ProveInclusion(make([]byte, 32))
+	assert.Equal(t, ErrTxNotInBlock, err)
+
+	_, err = NewBlock().ProveInclusion(txID)
+	assert.Equal(t, ErrTxNotInBlock, err)
+}
+
+func TestProveInclusions(t *testing.T) {
+	blk := NewBlock()
+	blk.AddTx(randomCoinbase(t))
+	for i := 0; i < 4; i++ {
+		blk.AddTx(randomTx(t, 0, 100))
+	}
+	seal(t, blk)
+
+	// Proofs are returned in the order of the txids
+	var txIDs [][]byte
+	for _, i := range []int{4, 1, 2} {
+		txID, err := blk.Txs[i].CalculateHash()
+		assert.NoError(t, err)
+		txIDs = append(txIDs, txID)
+	}
+
+	proofs, err := blk.ProveInclusions(txIDs)
+	assert.NoError(t, err)
+	assert.Equal(t, len(txIDs), len(proofs))
+	for i, txID := range txIDs {
+		assert.NoError(t, proofs[i].Verify(txID, blk.Header.TxRoot))
+
+		proof, err := blk.ProveInclusion(txID)
+		assert.NoError(t, err)
+		assert.Equal(t, proof, proofs[i])
+	}
+
+	// A single missing tx fails the whole batch
+	_, err = blk.ProveInclusions(append(txIDs, make([]byte, 32)))
+	assert.Equal(t, ErrTxNotInBlock, err)
+
+	proofs, err = blk.ProveInclusions(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, proofs)
+}
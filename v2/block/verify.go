@@ -0,0 +1,60 @@
+package block
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	// ErrInvalidHash is returned for blocks whose hash does not match the
+	// fields of their header
+	ErrInvalidHash = errors.New("block hash does not match the header")
+	// ErrInvalidTxRoot is returned for blocks whose tx root does not match
+	// their txs
+	ErrInvalidTxRoot = errors.New("tx root does not match the block txs")
+	// ErrPrevBlockMismatch is returned for blocks which do not follow the
+	// previous block of the chain
+	ErrPrevBlockMismatch = errors.New("previous block hash does not match the chain")
+)
+
+// Verify checks that the header hash and the tx root match the contents of
+// the block. A block without txs has no merkle tree, so its tx root has to
+// be empty.
+func (b *Block) Verify() error {
+	hash, err := b.Header.CalculateHash()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(hash, b.Header.Hash) {
+		return ErrInvalidHash
+	}
+
+	if len(b.Txs) == 0 {
+		if len(b.Header.TxRoot) != 0 {
+			return ErrInvalidTxRoot
+		}
+		return nil
+	}
+
+	root, err := b.CalculateRoot()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(root, b.Header.TxRoot) {
+		return ErrInvalidTxRoot
+	}
+
+	return nil
+}
+
+// Follows checks that the block links to the block with header hash
+// `prevHash`.
+func (b *Block) Follows(prevHash []byte) error {
+	if !bytes.Equal(b.Header.PrevBlockHash, prevHash) {
+		return ErrPrevBlockMismatch
+	}
+
+	return nil
+}
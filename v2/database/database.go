@@ -5,8 +5,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 
-	"github.com/dusk-network/dusk-wallet/v2/key"
 	"github.com/dusk-network/dusk-wallet/v2/transactions"
 	"github.com/dusk-network/dusk-wallet/v2/txrecords"
 
@@ -25,30 +25,54 @@ var (
 	walletHeightPrefix = []byte{0x01}
 	txRecordPrefix     = []byte{0x02}
 	keyImagePrefix     = []byte{0x03}
+	txHeightPrefix     = []byte{0x05}
+	outboxPrefix       = []byte{0x06}
+	pendingPrefix      = []byte{0x07}
+	spentPrefix        = []byte{0x08}
+	headerPrefix       = []byte{0x09}
+	deliveredPrefix    = []byte{0x0a}
+	notePrefix         = []byte{0x0b}
+	legacyPrefix       = []byte{0x0c}
+	changePrefix       = []byte{0x0d}
 
 	writeOptions = &opt.WriteOptions{NoWriteMerge: false, Sync: true}
 )
 
 func New(path string) (*DB, error) {
-	db, err := leveldb.OpenFile(path, nil)
+	storage, err := leveldb.OpenFile(path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("wallet cannot be used without database %s", err.Error())
 	}
-	return &DB{storage: db}, nil
+
+	db := &DB{storage: storage}
+	if err := db.moveLegacyTxRecords(); err != nil {
+		_ = storage.Close()
+		return nil, err
+	}
+
+	return db, nil
 }
 
 func (db *DB) Put(key, value []byte) error {
 	return db.storage.Put(key, value, nil)
 }
 
-func (db *DB) PutInput(encryptionKey []byte, pubkey ristretto.Point, amount, mask, privKey ristretto.Scalar, unlockHeight uint64, nonce uint64) error {
+// PutInput stores an output received by the wallet, which was included in the
+// tx with id `txID`, in the block at `height`. If the output is locked,
+// `lockReason` tells why.
+func (db *DB) PutInput(encryptionKey []byte, pubkey ristretto.Point, amount, mask, privKey ristretto.Scalar, unlockHeight uint64, lockReason transactions.LockReason, txID []byte, height uint64, nonce uint64) error {
 
 	buf := &bytes.Buffer{}
 	idb := &inputDB{
-		amount:       amount,
-		mask:         mask,
-		privKey:      privKey,
-		unlockHeight: unlockHeight,
+		amount:              amount,
+		mask:                mask,
+		privKey:             privKey,
+		unlockHeight:        unlockHeight,
+		lockReason:          lockReason,
+		txID:                txID,
+		height:              height,
+		initialUnlockHeight: unlockHeight,
+		initialLockReason:   lockReason,
 	}
 
 	if err := idb.Encode(buf); err != nil {
@@ -68,17 +92,51 @@ func (db *DB) PutInput(encryptionKey []byte, pubkey ristretto.Point, amount, mas
 	return db.Put(key, encryptedBytes)
 }
 
+// RemoveInput deletes the input belonging to the given one-time pubkey,
+// along with its cached key image.
 func (db *DB) RemoveInput(pubkey []byte, keyImage []byte) error {
-	inputKey := append(inputPrefix, pubkey...)
 	keyImageKey := append(keyImagePrefix, keyImage...)
 
 	b := new(leveldb.Batch)
-	b.Delete(inputKey)
 	b.Delete(keyImageKey)
+	if err := db.deleteInput(b, pubkey); err != nil {
+		return err
+	}
+
+	return db.storage.Write(b, writeOptions)
+}
+
+// DeleteInput deletes the input belonging to the given one-time pubkey, but
+// keeps its key image, so that the input is still recognised once it
+// is spent in a block. The input is moved to the spent archive, as spent
+// by a pending tx.
+func (db *DB) DeleteInput(pubkey []byte) error {
+	b := new(leveldb.Batch)
+	if err := db.archiveInput(b, pubkey, nil, pendingSpend); err != nil {
+		return err
+	}
 
 	return db.storage.Write(b, writeOptions)
 }
 
+func (db *DB) deleteInput(b *leveldb.Batch, pubkey []byte) error {
+	// Inputs are stored under `inputPrefix + pubkey + nonce`, so we need to
+	// look up the full key by prefix.
+	inputKey := make([]byte, 0, len(inputPrefix)+len(pubkey))
+	inputKey = append(inputKey, inputPrefix...)
+	inputKey = append(inputKey, pubkey...)
+
+	iter := db.storage.NewIterator(util.BytesPrefix(inputKey), nil)
+	defer iter.Release()
+	for iter.Next() {
+		k := make([]byte, len(iter.Key()))
+		copy(k, iter.Key())
+		b.Delete(k)
+	}
+
+	return iter.Error()
+}
+
 func (db *DB) FetchInputs(decryptionKey []byte, amount int64) ([]*transactions.Input, int64, error) {
 
 	var inputs []*inputDB
@@ -105,8 +163,8 @@ func (db *DB) FetchInputs(decryptionKey []byte, amount int64) ([]*transactions.I
 			return nil, 0, err
 		}
 
-		// Only add unlocked inputs
-		if idb.unlockHeight == 0 {
+		// Only add unlocked inputs, which were not frozen by the user
+		if idb.unlockHeight == 0 && !idb.frozen {
 			inputs = append(inputs, idb)
 
 			// Check if we need more inputs
@@ -140,6 +198,39 @@ func (db *DB) FetchInputs(decryptionKey []byte, amount int64) ([]*transactions.I
 	return tInputs, changeAmount, nil
 }
 
+// FetchDustInputs returns all unlocked inputs which are worth less than
+// `threshold`.
+func (db *DB) FetchDustInputs(decryptionKey []byte, threshold int64) ([]*transactions.Input, error) {
+	var inputs []*transactions.Input
+
+	iter := db.storage.NewIterator(util.BytesPrefix(inputPrefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		val := iter.Value()
+
+		encryptedBytes := make([]byte, len(val))
+		copy(encryptedBytes[:], val)
+
+		decryptedBytes, err := decrypt(encryptedBytes, decryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		idb := &inputDB{}
+
+		buf := bytes.NewBuffer(decryptedBytes)
+		err = idb.Decode(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		if idb.unlockHeight == 0 && !idb.frozen && idb.amount.BigInt().Int64() < threshold {
+			inputs = append(inputs, transactions.NewInput(idb.amount, idb.mask, idb.privKey))
+		}
+	}
+
+	return inputs, iter.Error()
+}
+
 func (db *DB) FetchBalance(decryptionKey []byte) (uint64, uint64, error) {
 	var unlockedBalance ristretto.Scalar
 	unlockedBalance.SetZero()
@@ -184,46 +275,52 @@ func (db *DB) FetchBalance(decryptionKey []byte) (uint64, uint64, error) {
 
 // UpdateLockedInputs will set the lockheight for an input to 0 if the
 // given `height` is greater or equal than the input lockheight,
-// signifying that this input is unlocked.
-func (db *DB) UpdateLockedInputs(decryptionKey []byte, height uint64) error {
+// signifying that this input is unlocked. The outputs which were unlocked
+// are returned, with the reason they were locked for.
+func (db *DB) UpdateLockedInputs(decryptionKey []byte, height uint64) ([]OwnedOutput, error) {
+	var unlocked []OwnedOutput
+
 	iter := db.storage.NewIterator(util.BytesPrefix(inputPrefix), nil)
 	defer iter.Release()
 	for iter.Next() {
-		val := iter.Value()
-
-		encryptedBytes := make([]byte, len(val))
-		copy(encryptedBytes[:], val)
-
-		decryptedBytes, err := decrypt(encryptedBytes, decryptionKey)
+		idb, err := decodeInput(iter.Value(), decryptionKey)
 		if err != nil {
-			return err
-		}
-		idb := &inputDB{}
-
-		buf := bytes.NewBuffer(decryptedBytes)
-		err = idb.Decode(buf)
-		if err != nil {
-			return err
+			return nil, err
 		}
 
+		// The lock the input was received with is kept, so that a rescan
+		// can lock it again
 		if idb.unlockHeight != 0 && idb.unlockHeight <= height {
+			// key: inputPrefix + pubkey + nonce
+			var pubKeyBytes [32]byte
+			copy(pubKeyBytes[:], iter.Key()[len(inputPrefix):])
+			output := OwnedOutput{
+				Amount:       idb.amount.BigInt().Uint64(),
+				UnlockHeight: idb.unlockHeight,
+				LockReason:   idb.lockReason,
+				TxID:         idb.txID,
+				Height:       idb.height,
+				Frozen:       idb.frozen,
+			}
+			output.PubKey.SetBytes(&pubKeyBytes)
+
 			idb.unlockHeight = 0
+			idb.lockReason = transactions.LockNone
 			// Overwrite input
-			buf := new(bytes.Buffer)
-			if err := idb.Encode(buf); err != nil {
-				return err
+			encryptedBytes, err := encodeInput(idb, decryptionKey)
+			if err != nil {
+				return nil, err
 			}
 
-			encryptedBytes, err := encrypt(buf.Bytes(), decryptionKey)
-			if err != nil {
-				return err
+			if err := db.Put(iter.Key(), encryptedBytes); err != nil {
+				return nil, err
 			}
 
-			db.Put(iter.Key(), encryptedBytes)
+			unlocked = append(unlocked, output)
 		}
 	}
 
-	return iter.Error()
+	return unlocked, iter.Error()
 }
 
 func (db *DB) GetWalletHeight() (uint64, error) {
@@ -254,73 +351,396 @@ func (db *DB) Close() error {
 	return db.storage.Close()
 }
 
+// FetchTxRecords returns all tx records stored in the database. If records
+// of an older wallet version are left, ErrLegacyTxRecords is returned.
 func (db *DB) FetchTxRecords() ([]txrecords.TxRecord, error) {
+	if err := db.checkLegacyTxRecords(); err != nil {
+		return nil, err
+	}
+
 	records := make([]txrecords.TxRecord, 0)
 	iter := db.storage.NewIterator(util.BytesPrefix(txRecordPrefix), nil)
 	defer iter.Release()
 
 	for iter.Next() {
-		// record is the key without the prefix
-		val := iter.Key()[1:]
+		txRecord, err := decodeTxRecord(iter.Value())
+		if err != nil {
+			return nil, err
+		}
 
-		bs := make([]byte, len(val))
-		copy(bs[:], val)
+		records = append(records, *txRecord)
+	}
 
-		txRecord := txrecords.TxRecord{}
+	err := iter.Error()
+	return records, err
+}
 
-		if err := txrecords.Decode(bytes.NewBuffer(bs), &txRecord); err != nil {
+// FetchTxRecordsFromHeight returns the tx records at `height` and above,
+// ordered by height.
+func (db *DB) FetchTxRecordsFromHeight(height uint64) ([]txrecords.TxRecord, error) {
+	records := make([]txrecords.TxRecord, 0)
+	iter := db.storage.NewIterator(&util.Range{
+		Start: txHeightKey(height, nil),
+		Limit: util.BytesPrefix(txHeightPrefix).Limit,
+	}, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		// key: txHeightPrefix + height + txid
+		txID := iter.Key()[len(txHeightPrefix)+8:]
+		txRecord, err := db.GetTxRecord(txID)
+		if err != nil {
 			return nil, err
 		}
 
-		records = append(records, txRecord)
+		records = append(records, *txRecord)
 	}
 
 	err := iter.Error()
 	return records, err
 }
 
-func (db *DB) PutTxRecord(tx transactions.Transaction, direction txrecords.Direction, privView *key.PrivateView) error {
+// QueryTxRecords returns the page of tx records selected by `q`, along with
+// the cursor of the next page, like txrecords.Query.Apply. Queries ordered by
+// height walk the height index from their cursor, and stop once the page is
+// full. Other orders need all records in the height range, which are loaded
+// and sorted. If records of an older wallet version are left,
+// ErrLegacyTxRecords is returned.
+func (db *DB) QueryTxRecords(q txrecords.Query) ([]txrecords.TxRecord, string, error) {
+	if err := db.checkLegacyTxRecords(); err != nil {
+		return nil, "", err
+	}
+
+	if q.SortBy != txrecords.ByHeight {
+		records, err := db.FetchTxRecordsFromHeight(q.MinHeight)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return q.Apply(records)
+	}
+
+	after, err := q.After()
+	if err != nil {
+		return nil, "", err
+	}
+
+	r := util.BytesPrefix(txHeightPrefix)
+	r.Start = txHeightKey(q.MinHeight, nil)
+	if q.MaxHeight != 0 && q.MaxHeight != math.MaxUint64 {
+		r.Limit = txHeightKey(q.MaxHeight+1, nil)
+	}
+
+	iter := db.storage.NewIterator(r, nil)
+	defer iter.Release()
+
+	// The keys of the height index follow the layout of the query
+	// positions, so the cursor is looked up directly
+	cursor := append(append([]byte{}, txHeightPrefix...), after...)
+
+	var ok bool
+	switch {
+	case after == nil && q.Descending:
+		ok = iter.Last()
+	case after == nil:
+		ok = iter.First()
+	case q.Descending:
+		if iter.Seek(cursor) {
+			ok = iter.Prev()
+		} else {
+			ok = iter.Last()
+		}
+	default:
+		ok = iter.Seek(cursor)
+		if ok && bytes.Equal(iter.Key(), cursor) {
+			ok = iter.Next()
+		}
+	}
+
+	next := iter.Next
+	if q.Descending {
+		next = iter.Prev
+	}
+
+	results := make([]txrecords.TxRecord, 0)
+	for ; ok; ok = next() {
+		// key: txHeightPrefix + height + txid
+		txID := iter.Key()[len(txHeightPrefix)+8:]
+		txRecord, err := db.GetTxRecord(txID)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if !q.Matches(*txRecord) {
+			continue
+		}
+
+		// Another match follows the full page
+		if q.Limit > 0 && len(results) == q.Limit {
+			return results, q.NextCursor(results[q.Limit-1]), nil
+		}
+
+		results = append(results, *txRecord)
+	}
+
+	return results, "", iter.Error()
+}
+
+// GetTxRecord returns the record of the tx with id `txID`, or
+// leveldb.ErrNotFound if there is none.
+func (db *DB) GetTxRecord(txID []byte) (*txrecords.TxRecord, error) {
+	value, err := db.Get(txRecordKey(txID))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeTxRecord(value)
+}
+
+// PutTxRecord stores `txRecord` under its txid, replacing the record which
+// was stored for the same tx before.
+func (db *DB) PutTxRecord(txRecord *txrecords.TxRecord) error {
 	// Schema
 	//
-	// key: txRecordPrefix + record
-	// value: 0
-	buf := new(bytes.Buffer)
-	height, err := db.GetWalletHeight()
-	if err != nil {
+	// key: txRecordPrefix + txid
+	// value: record
+	//
+	// key: txHeightPrefix + height + txid
+	// value: empty
+	b := new(leveldb.Batch)
+	if err := db.putTxRecord(b, txRecord); err != nil {
 		return err
 	}
 
-	txRecord := txrecords.New(tx, height, direction, privView)
+	return db.storage.Write(b, writeOptions)
+}
+
+// putTxRecord adds the writes storing `txRecord` to `b`.
+func (db *DB) putTxRecord(b *leveldb.Batch, txRecord *txrecords.TxRecord) error {
+	if len(txRecord.TxID) == 0 {
+		return errors.New("tx record has no txid")
+	}
+
+	old, err := db.GetTxRecord(txRecord.TxID)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+
+	if err == nil {
+		b.Delete(txHeightKey(old.Height, old.TxID))
+	} else if err := db.restoreTxNote(b, txRecord); err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
 	if err := txrecords.Encode(buf, txRecord); err != nil {
 		return err
 	}
 
-	key := make([]byte, 0)
+	b.Put(txRecordKey(txRecord.TxID), buf.Bytes())
+	b.Put(txHeightKey(txRecord.Height, txRecord.TxID), []byte{})
+	return nil
+}
+
+// UpdateTxRecord applies `f` to the record of the tx with id `txID`, and
+// stores the result.
+func (db *DB) UpdateTxRecord(txID []byte, f func(*txrecords.TxRecord)) error {
+	txRecord, err := db.GetTxRecord(txID)
+	if err != nil {
+		return err
+	}
+
+	f(txRecord)
+	if !bytes.Equal(txRecord.TxID, txID) {
+		return errors.New("the txid of a tx record can not be changed")
+	}
+
+	return db.PutTxRecord(txRecord)
+}
+
+func txRecordKey(txID []byte) []byte {
+	key := make([]byte, 0, len(txRecordPrefix)+len(txID))
 	key = append(key, txRecordPrefix...)
-	key = append(key, buf.Bytes()...)
+	return append(key, txID...)
+}
 
-	value := make([]byte, 1)
+func txHeightKey(height uint64, txID []byte) []byte {
+	// Big endian, so that the index is ordered by height
+	key := make([]byte, len(txHeightPrefix)+8, len(txHeightPrefix)+8+len(txID))
+	copy(key, txHeightPrefix)
+	binary.BigEndian.PutUint64(key[len(txHeightPrefix):], height)
+	return append(key, txID...)
+}
+
+func decodeTxRecord(value []byte) (*txrecords.TxRecord, error) {
+	bs := make([]byte, len(value))
+	copy(bs[:], value)
+
+	txRecord := &txrecords.TxRecord{}
+	if err := txrecords.Decode(bytes.NewBuffer(bs), txRecord); err != nil {
+		return nil, err
+	}
+
+	return txRecord, nil
+}
+
+// PutKeyImage stores the key image of an owned output, along with the
+// output's one-time pubkey and amount.
+func (db *DB) PutKeyImage(keyImage []byte, outputKey []byte, amount uint64) error {
+	key := append(keyImagePrefix, keyImage...)
+
+	value := make([]byte, len(outputKey)+8)
+	copy(value, outputKey)
+	binary.LittleEndian.PutUint64(value[len(outputKey):], amount)
 	return db.Put(key, value)
 }
 
-func (db *DB) PutKeyImage(keyImage []byte, outputKey []byte) error {
+// PutChangeOutput remembers that the output with the given one-time pubkey
+// returns change to the wallet. The role of an output can not be told from
+// the chain, so the wallet records its change outputs when it builds a tx.
+func (db *DB) PutChangeOutput(pubkey []byte) error {
+	return db.storage.Put(changeKey(pubkey), []byte{}, writeOptions)
+}
+
+// IsChangeOutput returns true if the output with the given one-time pubkey
+// was stored with PutChangeOutput.
+func (db *DB) IsChangeOutput(pubkey []byte) (bool, error) {
+	return db.storage.Has(changeKey(pubkey), nil)
+}
+
+func changeKey(pubkey []byte) []byte {
+	key := make([]byte, 0, len(changePrefix)+len(pubkey))
+	key = append(key, changePrefix...)
+	return append(key, pubkey...)
+}
+
+// GetKeyImage returns the one-time pubkey and amount of the output
+// belonging to the given key image.
+func (db *DB) GetKeyImage(keyImage []byte) ([]byte, uint64, error) {
 	key := append(keyImagePrefix, keyImage...)
-	return db.Put(key, outputKey)
+	value, err := db.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Older entries only hold the pubkey
+	if len(value) < 40 {
+		return value, 0, nil
+	}
+
+	return value[:32], binary.LittleEndian.Uint64(value[32:]), nil
 }
 
 func (db *DB) GetPubKey(keyImage []byte) ([]byte, error) {
-	key := append(keyImagePrefix, keyImage...)
-	return db.Get(key)
+	outputKey, _, err := db.GetKeyImage(keyImage)
+	return outputKey, err
 }
 
-// Clear all information from the database.
-func (db *DB) Clear() error {
-	iter := db.storage.NewIterator(nil, nil)
+// OutboxEntry is an entry of the notification outbox.
+type OutboxEntry struct {
+	ID    []byte
+	Value []byte
+}
+
+// PutOutboxEntry stores an outbox entry, replacing the entry with the same
+// id.
+func (db *DB) PutOutboxEntry(id, value []byte) error {
+	key := append(outboxPrefix, id...)
+	return db.storage.Put(key, value, writeOptions)
+}
+
+// GetOutboxEntry returns the value of the queued outbox entry with the
+// given id.
+func (db *DB) GetOutboxEntry(id []byte) ([]byte, error) {
+	return db.Get(append(append([]byte{}, outboxPrefix...), id...))
+}
+
+// DeleteOutboxEntry removes the outbox entry with the given id.
+func (db *DB) DeleteOutboxEntry(id []byte) error {
+	key := append(outboxPrefix, id...)
+	return db.storage.Delete(key, writeOptions)
+}
+
+// FinishOutboxEntry removes the outbox entry with the given id, and
+// remembers it was finished at `finishedAt`, a unix timestamp.
+func (db *DB) FinishOutboxEntry(id []byte, finishedAt int64) error {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, uint64(finishedAt))
+
+	b := new(leveldb.Batch)
+	b.Delete(append(outboxPrefix, id...))
+	b.Put(append(deliveredPrefix, id...), value)
+	return db.storage.Write(b, writeOptions)
+}
+
+// HasOutboxEntry returns true if the outbox entry with the given id is
+// queued, or was finished and not pruned yet.
+func (db *DB) HasOutboxEntry(id []byte) (bool, error) {
+	for _, prefix := range [][]byte{outboxPrefix, deliveredPrefix} {
+		ok, err := db.storage.Has(append(append([]byte{}, prefix...), id...), nil)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+// PruneFinishedOutboxEntries forgets the outbox entries finished before
+// `before`, a unix timestamp.
+func (db *DB) PruneFinishedOutboxEntries(before int64) error {
+	b := new(leveldb.Batch)
+	iter := db.storage.NewIterator(util.BytesPrefix(deliveredPrefix), nil)
+	for iter.Next() {
+		if int64(binary.LittleEndian.Uint64(iter.Value())) < before {
+			b.Delete(copyBytes(iter.Key()))
+		}
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	return db.storage.Write(b, writeOptions)
+}
+
+// FetchOutboxEntries returns all outbox entries which were not finished.
+func (db *DB) FetchOutboxEntries() ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	iter := db.storage.NewIterator(util.BytesPrefix(outboxPrefix), nil)
 	defer iter.Release()
 
 	for iter.Next() {
-		db.Delete(iter.Key())
+		entry := OutboxEntry{
+			ID:    make([]byte, len(iter.Key())-len(outboxPrefix)),
+			Value: make([]byte, len(iter.Value())),
+		}
+		copy(entry.ID, iter.Key()[len(outboxPrefix):])
+		copy(entry.Value, iter.Value())
+		entries = append(entries, entry)
 	}
 
-	return iter.Error()
+	return entries, iter.Error()
+}
+
+// Clear all information from the database. The change outputs are kept, as
+// they can not be learnt from the chain again.
+func (db *DB) Clear() error {
+	b := new(leveldb.Batch)
+	iter := db.storage.NewIterator(nil, nil)
+	for iter.Next() {
+		if iter.Key()[0] == changePrefix[0] {
+			continue
+		}
+
+		b.Delete(copyBytes(iter.Key()))
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	return db.storage.Write(b, writeOptions)
 }
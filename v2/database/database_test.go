@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"math/big"
 	"math/rand"
 	"os"
 	"testing"
@@ -72,7 +73,7 @@ func TestUnlockInputs(t *testing.T) {
 	var pubKey ristretto.Point
 	pubKey.Rand()
 	r := rand.Uint64()
-	assert.NoError(t, db.PutInput([]byte{0}, pubKey, input.amount, input.mask, input.privKey, input.unlockHeight, r))
+	assert.NoError(t, db.PutInput([]byte{0}, pubKey, input.amount, input.mask, input.privKey, input.unlockHeight, transactions.LockTimelock, nil, 0, r))
 
 	// Fetch it and ensure the unlock height is set
 	key := append(inputPrefix, pubKey.Bytes()...)
@@ -88,7 +89,11 @@ func TestUnlockInputs(t *testing.T) {
 	assert.Equal(t, uint64(1000), decoded.unlockHeight)
 
 	// Now run UpdateLockedInputs
-	assert.NoError(t, db.UpdateLockedInputs([]byte{0}, 1000))
+	unlocked, err := db.UpdateLockedInputs([]byte{0}, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(unlocked))
+	assert.True(t, pubKey.Equals(&unlocked[0].PubKey))
+	assert.Equal(t, transactions.LockTimelock, unlocked[0].LockReason)
 
 	value, err = db.Get(key)
 	assert.NoError(t, err)
@@ -99,26 +104,236 @@ func TestUnlockInputs(t *testing.T) {
 	assert.Equal(t, uint64(0), decoded.unlockHeight)
 }
 
-func TestPutFetchTxRecord(t *testing.T) {
+func TestRemoveInput(t *testing.T) {
 	path := "mainnet"
 
 	// New
 	db, err := New(path)
 	assert.Nil(t, err)
 
-	db.UpdateWalletHeight(20)
+	// Make sure to delete this dir after test
+	defer os.RemoveAll(path)
+
+	input := randInput()
+
+	var pubKey, keyImage ristretto.Point
+	pubKey.Rand()
+	keyImage.Rand()
+	assert.NoError(t, db.PutInput([]byte{0}, pubKey, input.amount, input.mask, input.privKey, 0, transactions.LockNone, nil, 0, rand.Uint64()))
+	assert.NoError(t, db.PutKeyImage(keyImage.Bytes(), pubKey.Bytes(), input.amount.BigInt().Uint64()))
+
+	assert.NoError(t, db.RemoveInput(pubKey.Bytes(), keyImage.Bytes()))
+
+	// Both the input and the key image should be gone
+	_, err = db.GetPubKey(keyImage.Bytes())
+	assert.Equal(t, leveldb.ErrNotFound, err)
+
+	_, _, err = db.FetchInputs([]byte{0}, 1)
+	assert.Error(t, err)
+}
+
+func TestRescan(t *testing.T) {
+	path := "mainnet"
+
+	// New
+	db, err := New(path)
+	assert.Nil(t, err)
+
+	// Make sure to delete this dir after test
+	defer os.RemoveAll(path)
+
+	// Four outputs, received at height 5, 5, 5 and 15
+	heights := []uint64{5, 5, 5, 15}
+	pubKeys := make([]ristretto.Point, len(heights))
+	keyImages := make([]ristretto.Point, len(heights))
+	for i, height := range heights {
+		input := randInput()
+		input.amount.SetBigInt(big.NewInt(int64(100 * (i + 1))))
+		pubKeys[i].Rand()
+		keyImages[i].Rand()
+		assert.NoError(t, db.PutInput([]byte{0}, pubKeys[i], input.amount, input.mask, input.privKey, 0, transactions.LockNone, nil, height, rand.Uint64()))
+		assert.NoError(t, db.PutKeyImage(keyImages[i].Bytes(), pubKeys[i].Bytes(), input.amount.BigInt().Uint64()))
+	}
+
+	// The first output is spent at height 12, the second at height 8, and
+	// the third by a pending tx
+	assert.NoError(t, db.SpendInput(pubKeys[0].Bytes(), keyImages[0].Bytes(), 12))
+	assert.NoError(t, db.SpendInput(pubKeys[1].Bytes(), keyImages[1].Bytes(), 8))
+	assert.NoError(t, db.DeleteInput(pubKeys[2].Bytes()))
+
+	// Two locked outputs received at height 5, which unlock at height 8
+	// and 12
+	for i, unlockHeight := range []uint64{8, 12} {
+		input := randInput()
+		input.amount.SetBigInt(big.NewInt(int64(1000 * (i + 1))))
+		var pubKey ristretto.Point
+		pubKey.Rand()
+		assert.NoError(t, db.PutInput([]byte{0}, pubKey, input.amount, input.mask, input.privKey, unlockHeight, transactions.LockTimelock, nil, 5, rand.Uint64()))
+	}
+
+	unlocked, err := db.UpdateLockedInputs([]byte{0}, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(unlocked))
+
+	// Records at height 5 and 15, one of them labeled
+	records := make([]*txrecords.TxRecord, 3)
+	for i, height := range []uint64{5, 15, 15} {
+		tx, _ := randTxForRecord(transactions.StandardType)
+		records[i], err = txrecords.New(tx, height, 0, nil, map[uint32]uint64{0: 100}, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, db.PutTxRecord(records[i]))
+	}
+	assert.NoError(t, db.UpdateTxRecord(records[2].TxID, func(r *txrecords.TxRecord) {
+		r.PaymentID = []byte("invoice 42")
+		r.Label = "rent"
+		r.Counterparties = []string{"pippo", "pluto"}
+	}))
+
+	assert.NoError(t, db.UpdateWalletHeight(20))
+	assert.NoError(t, db.Rescan([]byte{0}, 10))
+
+	height, err := db.GetWalletHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(10), height)
+
+	// Only the output spent at height 12 is back, and the output unlocked
+	// at height 12 is locked again
+	unlockedBalance, lockedBalance, err := db.FetchBalance([]byte{0})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1100), unlockedBalance)
+	assert.Equal(t, uint64(2000), lockedBalance)
+
+	outputKey, amount, err := db.GetKeyImage(keyImages[0].Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, pubKeys[0].Bytes(), outputKey)
+	assert.Equal(t, uint64(100), amount)
+
+	for _, i := range []int{1, 3} {
+		_, err = db.GetPubKey(keyImages[i].Bytes())
+		assert.Equal(t, leveldb.ErrNotFound, err)
+	}
+
+	// The key image of the pending spend is kept
+	_, err = db.GetPubKey(keyImages[2].Bytes())
+	assert.NoError(t, err)
+
+	// Only the record below the rescan height is left
+	fetched, err := db.FetchTxRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(fetched))
+
+	for _, record := range records[1:] {
+		_, err = db.GetTxRecord(record.TxID)
+		assert.Equal(t, leveldb.ErrNotFound, err)
+	}
+
+	// The label comes back with the record, along with the payment id and
+	// counterparties
+	records[2].Label = ""
+	assert.NoError(t, db.PutTxRecord(records[2]))
+	record, err := db.GetTxRecord(records[2].TxID)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("invoice 42"), record.PaymentID)
+	assert.Equal(t, "rent", record.Label)
+	assert.Equal(t, []string{"pippo", "pluto"}, record.Counterparties)
+}
+
+func TestHeaderHashes(t *testing.T) {
+	path := "mainnet"
+
+	// New
+	db, err := New(path)
+	assert.Nil(t, err)
+
+	// Make sure to delete this dir after test
+	defer os.RemoveAll(path)
+
+	// Only the last 5 hashes are kept
+	for height := uint64(0); height < 10; height++ {
+		assert.NoError(t, db.PutHeaderHash(height, []byte{byte(height)}, 5))
+	}
+
+	for height := uint64(0); height < 10; height++ {
+		hash, err := db.GetHeaderHash(height)
+		if height < 5 {
+			assert.Equal(t, leveldb.ErrNotFound, err)
+			continue
+		}
+
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{byte(height)}, hash)
+	}
+
+	// A rescan removes the hashes from its height on
+	assert.NoError(t, db.Rescan([]byte{0}, 8))
+	_, err = db.GetHeaderHash(7)
+	assert.NoError(t, err)
+	_, err = db.GetHeaderHash(8)
+	assert.Equal(t, leveldb.ErrNotFound, err)
+}
+
+func TestDecodeInputWithoutExtraFields(t *testing.T) {
+	input := randInput()
+	input.unlockHeight = 1000
+
+	// Encode the input the way it was done before the txid, height and
+	// frozen flag were added.
+	buf := new(bytes.Buffer)
+	buf.Write(input.amount.Bytes())
+	buf.Write(input.mask.Bytes())
+	buf.Write(input.privKey.Bytes())
+	assert.NoError(t, binary.Write(buf, binary.LittleEndian, input.unlockHeight))
+
+	decoded := &inputDB{}
+	assert.NoError(t, decoded.Decode(buf))
+	assert.Equal(t, input.amount, decoded.amount)
+	assert.Equal(t, input.unlockHeight, decoded.unlockHeight)
+	assert.False(t, decoded.frozen)
+}
+
+func TestPutFetchTxRecord(t *testing.T) {
+	path := "mainnet"
+
+	// New
+	db, err := New(path)
+	assert.Nil(t, err)
 
 	// Make sure to delete this dir after test
 	defer os.RemoveAll(path)
 
-	// Create some random txs
+	// Create some random txs. Every other one is sent by us.
 	txs := make([]transactions.Transaction, 10)
-	privViews := make([]*key.PrivateView, 10)
+	amounts := make([]uint64, 10)
 	for i := range txs {
 		tx, privView := randTxForRecord(transactions.TxType(i % 5))
-		privViews[i] = privView
 		txs[i] = tx
-		if err := db.PutTxRecord(tx, txrecords.Direction(i%2), privView); err != nil {
+
+		amount := tx.StandardTx().Outputs[0].EncryptedAmount
+		if transactions.ShouldEncryptValues(tx) {
+			amount = transactions.DecryptAmount(amount, tx.StandardTx().R, 0, *privView)
+		}
+		amounts[i] = amount.BigInt().Uint64()
+
+		owned := map[uint32]uint64{0: amounts[i]}
+		var spent uint64
+		if i%2 == 1 {
+			// We paid for the output, plus a fee of 100
+			owned = map[uint32]uint64{}
+			spent = amounts[i] + 100
+			amounts[i] = spent
+		}
+
+		record, err := txrecords.New(tx, uint64(20+i%3), int64(i), nil, owned, spent)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.PutTxRecord(record); err != nil {
+			t.Fatal(err)
+		}
+
+		// Storing the same record again does not duplicate it
+		if err := db.PutTxRecord(record); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -131,25 +346,232 @@ func TestPutFetchTxRecord(t *testing.T) {
 
 	// Check correctness
 	assert.Equal(t, len(txs), len(records))
-	checked := 0
 	for _, record := range records {
 		// Find out which tx this is
-		for i, tx := range txs {
-			if hex.EncodeToString(tx.StandardTx().Outputs[0].PubKey.P.Bytes()) == record.Recipient {
-				assert.Equal(t, tx.LockTime(), record.UnlockHeight-record.Height)
-				assert.Equal(t, tx.Type(), record.TxType)
-				amount := tx.StandardTx().Outputs[0].EncryptedAmount
-				if transactions.ShouldEncryptValues(tx) {
-					amount = transactions.DecryptAmount(amount, tx.StandardTx().R, 0, *privViews[i])
-				}
-
-				assert.Equal(t, amount.BigInt().Uint64(), record.Amount)
-				checked++
+		i := record.Timestamp
+		tx := txs[i]
+
+		_, lockTime := transactions.OutputLock(tx, 0, transactions.PaymentOutput, 0)
+		assert.Equal(t, lockTime, record.UnlockHeight-record.Height)
+		assert.Equal(t, tx.Type(), record.TxType)
+		assert.Equal(t, amounts[i], record.Amount)
+		assert.Equal(t, txrecords.Direction(i%2), record.Direction)
+
+		if record.Direction == txrecords.Out {
+			assert.Equal(t, []string{hex.EncodeToString(tx.StandardTx().Outputs[0].PubKey.P.Bytes())}, record.Recipients)
+		}
+	}
+
+	// Records can be fetched by height
+	records, err = db.FetchTxRecordsFromHeight(21)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(records))
+	for i := 1; i < len(records); i++ {
+		assert.True(t, records[i-1].Height <= records[i].Height)
+	}
+
+	// And looked up and updated by txid
+	txID, err := txs[0].CalculateHash()
+	assert.NoError(t, err)
+	assert.NoError(t, db.UpdateTxRecord(txID, func(record *txrecords.TxRecord) {
+		record.Height = 30
+		record.PaymentID = []byte("invoice")
+	}))
+
+	record, err := db.GetTxRecord(txID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(30), record.Height)
+	assert.Equal(t, []byte("invoice"), record.PaymentID)
+
+	records, err = db.FetchTxRecordsFromHeight(30)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, txID, records[0].TxID)
+
+	_, err = db.GetTxRecord(make([]byte, 32))
+	assert.Equal(t, leveldb.ErrNotFound, err)
+}
+
+func TestLegacyTxRecords(t *testing.T) {
+	path := "mainnet"
+
+	// New
+	db, err := New(path)
+	assert.Nil(t, err)
+
+	// Make sure to delete this dir after test
+	defer os.RemoveAll(path)
+
+	tx, _ := randTxForRecord(transactions.StandardType)
+	record, err := txrecords.New(tx, 20, 0, nil, map[uint32]uint64{0: 100}, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, db.PutTxRecord(record))
+
+	// Older wallet versions stored the direction, timestamp and height in
+	// the key, with a dummy value
+	for _, height := range []uint64{5, 12} {
+		legacy := make([]byte, 1+1+8+8+1+8+8)
+		legacy[0] = txRecordPrefix[0]
+		binary.LittleEndian.PutUint64(legacy[2+8:], height)
+		assert.NoError(t, db.Put(legacy, []byte{0}))
+	}
+
+	// Reopening moves them out of the way, and the history can not be
+	// fetched until they are rescanned
+	assert.NoError(t, db.Close())
+	db, err = New(path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.FetchTxRecords()
+	assert.Equal(t, ErrLegacyTxRecords, err)
+	_, _, err = db.QueryTxRecords(txrecords.Query{})
+	assert.Equal(t, ErrLegacyTxRecords, err)
+
+	fetched, err := db.FetchTxRecordsFromHeight(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(fetched))
+
+	assert.NoError(t, db.Rescan([]byte{0}, 10))
+	_, err = db.FetchTxRecords()
+	assert.Equal(t, ErrLegacyTxRecords, err)
+
+	assert.NoError(t, db.Rescan([]byte{0}, 5))
+	records, err := db.FetchTxRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(records))
+}
+
+func TestQueryTxRecords(t *testing.T) {
+	path := "mainnet"
+
+	// New
+	db, err := New(path)
+	assert.Nil(t, err)
+
+	// Make sure to delete this dir after test
+	defer os.RemoveAll(path)
+
+	// Five records at each of ten heights
+	records := make([]txrecords.TxRecord, 0, 50)
+	for i := 0; i < 50; i++ {
+		txID := make([]byte, 32)
+		txID[0] = byte(i * 7 % 50)
+		r := txrecords.TxRecord{
+			TxID:      txID,
+			Direction: txrecords.Direction(i % 2),
+			Height:    uint64(i / 5),
+			Timestamp: int64(1000 - i),
+			TxType:    transactions.TxType(i % 5),
+			Amount:    uint64(i * 100),
+		}
+
+		assert.NoError(t, db.PutTxRecord(&r))
+		records = append(records, r)
+	}
+
+	// Walking the height index pages like sorting all records does
+	queries := []txrecords.Query{
+		{Limit: 7},
+		{Limit: 7, Descending: true},
+		{Limit: 3, MinHeight: 2, MaxHeight: 6},
+		{Limit: 3, MinHeight: 2, MaxHeight: 6, Descending: true},
+		{Limit: 4, Directions: []txrecords.Direction{txrecords.Out}, MinAmount: 1000},
+		{Limit: 5, SortBy: txrecords.ByTime, MaxHeight: 8},
+		{MaxHeight: 3},
+	}
+
+	for _, q := range queries {
+		pages := 0
+		for {
+			expected, expectedCursor, err := q.Apply(records)
+			assert.NoError(t, err)
+
+			page, cursor, err := db.QueryTxRecords(q)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedCursor, cursor)
+			assert.Equal(t, len(expected), len(page))
+			for i := range expected {
+				assert.Equal(t, expected[i].TxID, page[i].TxID)
 			}
+
+			pages++
+			if cursor == "" {
+				break
+			}
+			q.Cursor = cursor
 		}
+
+		assert.True(t, q.Limit == 0 || pages > 1)
+	}
+
+	// The cursor does not have to point at a stored record
+	q := txrecords.Query{Limit: 2, Cursor: hex.EncodeToString(append(make([]byte, 7), 3))}
+	page, _, err := db.QueryTxRecords(q)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page))
+	assert.Equal(t, uint64(3), page[0].Height)
+
+	q.Cursor = "zz"
+	_, _, err = db.QueryTxRecords(q)
+	assert.Equal(t, txrecords.ErrInvalidCursor, err)
+}
+
+func TestPendingTx(t *testing.T) {
+	path := "mainnet"
+
+	// New
+	db, err := New(path)
+	assert.Nil(t, err)
+
+	// Make sure to delete this dir after test
+	defer os.RemoveAll(path)
+
+	records := make([]*txrecords.TxRecord, 3)
+	for i := range records {
+		tx, _ := randTxForRecord(transactions.StandardType)
+		records[i], err = txrecords.New(tx, 0, 0, nil, map[uint32]uint64{0: 100}, uint64(i*150))
+		assert.NoError(t, err)
+
+		// Confirmed records can not be stored as pending
+		assert.Error(t, db.PutPendingTx(records[i], nil))
+
+		records[i].State = txrecords.Pending
+		keyImage := make([]byte, 32)
+		keyImage[0] = byte(i)
+		assert.NoError(t, db.PutPendingTx(records[i], [][]byte{keyImage}))
 	}
 
-	assert.Equal(t, len(txs), checked)
+	// The first record is incoming, the others outgoing
+	incoming, outgoing, err := db.FetchPendingBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), incoming)
+	assert.Equal(t, uint64(50+200), outgoing)
+
+	pending, err := db.FetchPendingTxs()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(pending))
+	for _, p := range pending {
+		assert.Equal(t, 1, len(p.KeyImages))
+	}
+
+	// Confirming keeps the record, dropping removes it
+	assert.NoError(t, db.ConfirmPendingTx(records[0].TxID))
+	assert.NoError(t, db.DropPendingTx(records[1].TxID))
+
+	_, err = db.GetTxRecord(records[0].TxID)
+	assert.NoError(t, err)
+	_, err = db.GetTxRecord(records[1].TxID)
+	assert.Equal(t, leveldb.ErrNotFound, err)
+
+	fetched, err := db.FetchTxRecordsFromHeight(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(fetched))
+
+	incoming, outgoing, err = db.FetchPendingBalance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), incoming)
+	assert.Equal(t, uint64(200), outgoing)
 }
 
 func TestClear(t *testing.T) {
@@ -170,6 +592,9 @@ func TestClear(t *testing.T) {
 	err = db.Put(key, value)
 	assert.NoError(t, err)
 
+	change := []byte("change output pubkey")
+	assert.NoError(t, db.PutChangeOutput(change))
+
 	// Empty out database
 	assert.NoError(t, db.Clear())
 
@@ -179,6 +604,15 @@ func TestClear(t *testing.T) {
 
 	_, err = db.Get([]byte("hello"))
 	assert.Error(t, err)
+
+	// Except for the change outputs, which the chain does not tell
+	isChange, err := db.IsChangeOutput(change)
+	assert.NoError(t, err)
+	assert.True(t, isChange)
+
+	isChange, err = db.IsChangeOutput(key)
+	assert.NoError(t, err)
+	assert.False(t, isChange)
 }
 
 func randInput() *inputDB {
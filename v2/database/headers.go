@@ -0,0 +1,37 @@
+package database
+
+import (
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// PutHeaderHash stores the header hash of the block at `height`. Only the
+// last `keep` hashes are kept.
+func (db *DB) PutHeaderHash(height uint64, hash []byte, keep uint64) error {
+	// Schema
+	//
+	// key: headerPrefix + height
+	// value: header hash
+	b := new(leveldb.Batch)
+	b.Put(headerKey(height), hash)
+	if height >= keep {
+		b.Delete(headerKey(height - keep))
+	}
+
+	return db.storage.Write(b, writeOptions)
+}
+
+// GetHeaderHash returns the header hash of the block at `height`, or
+// leveldb.ErrNotFound if it is not stored.
+func (db *DB) GetHeaderHash(height uint64) ([]byte, error) {
+	return db.Get(headerKey(height))
+}
+
+func headerKey(height uint64) []byte {
+	// Big endian, so that the hashes are ordered by height
+	key := make([]byte, len(headerPrefix)+8)
+	copy(key, headerPrefix)
+	binary.BigEndian.PutUint64(key[len(headerPrefix):], height)
+	return key
+}
@@ -5,11 +5,24 @@ import (
 	"io"
 
 	"github.com/bwesterb/go-ristretto"
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
 )
 
 type inputDB struct {
 	amount, mask, privKey ristretto.Scalar
 	unlockHeight          uint64
+
+	// Fields below were added later, and can be missing from older records
+	txID       []byte
+	height     uint64
+	frozen     bool
+	lockReason transactions.LockReason
+
+	// initialUnlockHeight and initialLockReason hold the lock the input was
+	// received with. They are kept once it unlocks, so that a rescan can
+	// lock it again
+	initialUnlockHeight uint64
+	initialLockReason   transactions.LockReason
 }
 
 func (idb *inputDB) Decode(r io.Reader) error {
@@ -38,7 +51,65 @@ func (idb *inputDB) Decode(r io.Reader) error {
 	}
 	idb.unlockHeight = unlockHeight
 
-	return nil
+	var height uint64
+	err = binary.Read(r, binary.LittleEndian, &height)
+	if err == io.EOF {
+		// Record predates the extra fields
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	idb.height = height
+
+	var lenTxID uint8
+	if err := binary.Read(r, binary.LittleEndian, &lenTxID); err != nil {
+		return err
+	}
+
+	idb.txID = make([]byte, lenTxID)
+	if _, err := io.ReadFull(r, idb.txID); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &idb.frozen); err != nil {
+		return err
+	}
+
+	err = binary.Read(r, binary.LittleEndian, &idb.lockReason)
+	if err == io.EOF {
+		// Record predates the lock reason
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = binary.Read(r, binary.LittleEndian, &idb.initialUnlockHeight)
+	if err == io.EOF {
+		// Record predates the initial lock. Locks which were lifted already
+		// are not restored by a rescan
+		idb.initialUnlockHeight = idb.unlockHeight
+		idb.initialLockReason = idb.lockReason
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return binary.Read(r, binary.LittleEndian, &idb.initialLockReason)
+}
+
+// relock restores the initial lock of the input, if it was lifted at or
+// above `height`. It returns true if the input was locked again.
+func (idb *inputDB) relock(height uint64) bool {
+	if idb.unlockHeight != 0 || idb.initialUnlockHeight == 0 || idb.initialUnlockHeight < height {
+		return false
+	}
+
+	idb.unlockHeight = idb.initialUnlockHeight
+	idb.lockReason = idb.initialLockReason
+	return true
 }
 
 func (idb *inputDB) Encode(w io.Writer) error {
@@ -55,7 +126,42 @@ func (idb *inputDB) Encode(w io.Writer) error {
 		return err
 	}
 
-	return binary.Write(w, binary.LittleEndian, idb.unlockHeight)
+	err = binary.Write(w, binary.LittleEndian, idb.unlockHeight)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.LittleEndian, idb.height)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.LittleEndian, uint8(len(idb.txID)))
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.BigEndian, idb.txID)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.LittleEndian, idb.frozen)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.LittleEndian, idb.lockReason)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.LittleEndian, idb.initialUnlockHeight)
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, idb.initialLockReason)
 }
 
 func read32Bytes(r io.Reader) ([32]byte, error) {
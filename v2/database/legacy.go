@@ -0,0 +1,87 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ErrLegacyTxRecords is returned when fetching tx records while the database
+// still holds records of an older wallet version. Rescanning the wallet from
+// the height of the oldest of them replaces them.
+var ErrLegacyTxRecords = errors.New("tx records of an older wallet version need a rescan")
+
+// Older wallet versions stored tx records in the key, with a dummy value:
+//
+// key: txRecordPrefix + direction + timestamp + height + ...
+// value: 0
+//
+// These records do not hold the txid, so they can not be moved to the txid
+// keyed layout. Instead, they are moved out of its way when the database is
+// opened, and kept until a rescan covers their height.
+
+// legacyHeightOffset is the offset of the little endian height in a legacy
+// record, after the direction and the timestamp.
+const legacyHeightOffset = 1 + 8
+
+// moveLegacyTxRecords moves the legacy tx records stored under
+// txRecordPrefix to legacyPrefix.
+func (db *DB) moveLegacyTxRecords() error {
+	// Schema
+	//
+	// key: legacyPrefix + legacy record
+	// value: empty
+	b := new(leveldb.Batch)
+	iter := db.storage.NewIterator(util.BytesPrefix(txRecordPrefix), nil)
+	for iter.Next() {
+		if len(iter.Value()) > 1 {
+			continue
+		}
+
+		b.Delete(copyBytes(iter.Key()))
+		b.Put(append(copyBytes(legacyPrefix), iter.Key()[len(txRecordPrefix):]...), []byte{})
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	if b.Len() == 0 {
+		return nil
+	}
+
+	return db.storage.Write(b, writeOptions)
+}
+
+// checkLegacyTxRecords returns ErrLegacyTxRecords if any legacy tx record is
+// left.
+func (db *DB) checkLegacyTxRecords() error {
+	iter := db.storage.NewIterator(util.BytesPrefix(legacyPrefix), nil)
+	defer iter.Release()
+
+	if iter.First() {
+		return ErrLegacyTxRecords
+	}
+
+	return iter.Error()
+}
+
+// deleteLegacyTxRecords adds the deletion of the legacy tx records at or
+// above `height` to `b`. Records too short to hold a height are deleted as
+// well.
+func (db *DB) deleteLegacyTxRecords(b *leveldb.Batch, height uint64) error {
+	iter := db.storage.NewIterator(util.BytesPrefix(legacyPrefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		record := iter.Key()[len(legacyPrefix):]
+		if len(record) < legacyHeightOffset+8 || binary.LittleEndian.Uint64(record[legacyHeightOffset:]) >= height {
+			b.Delete(copyBytes(iter.Key()))
+		}
+	}
+
+	return iter.Error()
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/bwesterb/go-ristretto"
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// OwnedOutput describes an unspent output which belongs to the wallet.
+type OwnedOutput struct {
+	// PubKey is the one-time pubkey of the output
+	PubKey       ristretto.Point
+	Amount       uint64
+	UnlockHeight uint64
+	// LockReason tells why the output is locked, if it is
+	LockReason transactions.LockReason
+	// TxID is the id of the tx which created the output
+	TxID []byte
+	// Height is the height of the block which included the tx
+	Height uint64
+	// Frozen outputs are never picked when fetching inputs
+	Frozen bool
+}
+
+// FetchOwnedOutputs returns all unspent outputs stored in the database.
+func (db *DB) FetchOwnedOutputs(decryptionKey []byte) ([]OwnedOutput, error) {
+	var outputs []OwnedOutput
+
+	iter := db.storage.NewIterator(util.BytesPrefix(inputPrefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		idb, err := decodeInput(iter.Value(), decryptionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		// key: inputPrefix + pubkey + nonce
+		var pubKeyBytes [32]byte
+		copy(pubKeyBytes[:], iter.Key()[len(inputPrefix):])
+		var pubKey ristretto.Point
+		pubKey.SetBytes(&pubKeyBytes)
+
+		outputs = append(outputs, OwnedOutput{
+			PubKey:       pubKey,
+			Amount:       idb.amount.BigInt().Uint64(),
+			UnlockHeight: idb.unlockHeight,
+			LockReason:   idb.lockReason,
+			TxID:         idb.txID,
+			Height:       idb.height,
+			Frozen:       idb.frozen,
+		})
+	}
+
+	return outputs, iter.Error()
+}
+
+// FetchSelectedInputs returns the inputs for the outputs with the given
+// one-time pubkeys, along with their total amount. An error is returned
+// if any of the outputs is unknown, locked or frozen.
+func (db *DB) FetchSelectedInputs(decryptionKey []byte, pubKeys []ristretto.Point) ([]*transactions.Input, int64, error) {
+	inputs := make([]*transactions.Input, 0, len(pubKeys))
+	var total int64
+
+	for _, pubKey := range pubKeys {
+		found := false
+		err := db.iterateInput(decryptionKey, pubKey.Bytes(), func(key []byte, idb *inputDB) error {
+			if idb.unlockHeight != 0 {
+				return fmt.Errorf("output %x is locked", pubKey.Bytes())
+			}
+
+			if idb.frozen {
+				return fmt.Errorf("output %x is frozen", pubKey.Bytes())
+			}
+
+			found = true
+			total += idb.amount.BigInt().Int64()
+			inputs = append(inputs, transactions.NewInput(idb.amount, idb.mask, idb.privKey))
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !found {
+			return nil, 0, fmt.Errorf("output %x not found", pubKey.Bytes())
+		}
+	}
+
+	return inputs, total, nil
+}
+
+// SetFrozen freezes or thaws the output with the given one-time pubkey.
+// Frozen outputs are skipped by FetchInputs.
+func (db *DB) SetFrozen(decryptionKey []byte, pubKey []byte, frozen bool) error {
+	found := false
+	err := db.iterateInput(decryptionKey, pubKey, func(key []byte, idb *inputDB) error {
+		found = true
+		idb.frozen = frozen
+
+		// Overwrite input
+		encryptedBytes, err := encodeInput(idb, decryptionKey)
+		if err != nil {
+			return err
+		}
+
+		return db.Put(key, encryptedBytes)
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return leveldb.ErrNotFound
+	}
+
+	return nil
+}
+
+// iterateInput calls `f` for every input stored under the given pubkey.
+// Usually there is only one.
+func (db *DB) iterateInput(decryptionKey, pubKey []byte, f func(key []byte, idb *inputDB) error) error {
+	prefix := make([]byte, 0, len(inputPrefix)+len(pubKey))
+	prefix = append(prefix, inputPrefix...)
+	prefix = append(prefix, pubKey...)
+
+	iter := db.storage.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		idb, err := decodeInput(iter.Value(), decryptionKey)
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, len(iter.Key()))
+		copy(key, iter.Key())
+		if err := f(key, idb); err != nil {
+			return err
+		}
+	}
+
+	return iter.Error()
+}
+
+func decodeInput(val []byte, decryptionKey []byte) (*inputDB, error) {
+	encryptedBytes := make([]byte, len(val))
+	copy(encryptedBytes[:], val)
+
+	decryptedBytes, err := decrypt(encryptedBytes, decryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	idb := &inputDB{}
+	if err := idb.Decode(bytes.NewBuffer(decryptedBytes)); err != nil {
+		return nil, err
+	}
+
+	return idb, nil
+}
+
+func encodeInput(idb *inputDB, encryptionKey []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := idb.Encode(buf); err != nil {
+		return nil, err
+	}
+
+	return encrypt(buf.Bytes(), encryptionKey)
+}
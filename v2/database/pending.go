@@ -0,0 +1,183 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/dusk-network/dusk-wallet/v2/txrecords"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// keyImageSize is the size of a serialized key image.
+const keyImageSize = 32
+
+// PendingTx is a tx affecting the wallet which was not included in a
+// block yet.
+type PendingTx struct {
+	TxID []byte
+	// KeyImages are the key images of the tx inputs. A block spending any of
+	// them in another tx makes the pending tx invalid
+	KeyImages [][]byte
+}
+
+// PutPendingTx stores `txRecord`, which has to be in the pending state,
+// along with the key images of the tx inputs.
+func (db *DB) PutPendingTx(txRecord *txrecords.TxRecord, keyImages [][]byte) error {
+	// Schema
+	//
+	// key: pendingPrefix + txid
+	// value: key images
+	if txRecord.State != txrecords.Pending {
+		return errors.New("tx record is not pending")
+	}
+
+	value := make([]byte, 0, len(keyImages)*keyImageSize)
+	for _, keyImage := range keyImages {
+		if len(keyImage) != keyImageSize {
+			return errors.New("invalid key image size")
+		}
+		value = append(value, keyImage...)
+	}
+
+	b := new(leveldb.Batch)
+	if err := db.putTxRecord(b, txRecord); err != nil {
+		return err
+	}
+
+	b.Put(pendingKey(txRecord.TxID), value)
+	return db.storage.Write(b, writeOptions)
+}
+
+// FetchPendingTxs returns all pending txs.
+func (db *DB) FetchPendingTxs() ([]PendingTx, error) {
+	var pending []PendingTx
+	iter := db.storage.NewIterator(util.BytesPrefix(pendingPrefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		value := iter.Value()
+		if len(value)%keyImageSize != 0 {
+			return nil, errors.New("invalid pending tx entry")
+		}
+
+		p := PendingTx{
+			TxID:      make([]byte, len(iter.Key())-len(pendingPrefix)),
+			KeyImages: make([][]byte, 0, len(value)/keyImageSize),
+		}
+		copy(p.TxID, iter.Key()[len(pendingPrefix):])
+
+		for i := 0; i < len(value); i += keyImageSize {
+			keyImage := make([]byte, keyImageSize)
+			copy(keyImage, value[i:])
+			p.KeyImages = append(p.KeyImages, keyImage)
+		}
+
+		pending = append(pending, p)
+	}
+
+	return pending, iter.Error()
+}
+
+// ConfirmPendingTx removes the tx with id `txID` from the pending txs. Its
+// record is kept, to be confirmed by the caller.
+func (db *DB) ConfirmPendingTx(txID []byte) error {
+	return db.storage.Delete(pendingKey(txID), writeOptions)
+}
+
+// DropPendingTx removes the tx with id `txID` from the pending txs, along
+// with its record.
+func (db *DB) DropPendingTx(txID []byte) error {
+	b := new(leveldb.Batch)
+	if err := db.deletePendingTx(b, txID); err != nil {
+		return err
+	}
+
+	return db.storage.Write(b, writeOptions)
+}
+
+// AbandonPendingTx removes the tx with id `txID` from the pending txs, along
+// with its record, and restores the inputs it spends from the spent archive.
+// It returns leveldb.ErrNotFound if the tx is not pending.
+func (db *DB) AbandonPendingTx(txID []byte) error {
+	value, err := db.storage.Get(pendingKey(txID), nil)
+	if err != nil {
+		return err
+	}
+
+	if len(value)%keyImageSize != 0 {
+		return errors.New("invalid pending tx entry")
+	}
+
+	b := new(leveldb.Batch)
+	for i := 0; i < len(value); i += keyImageSize {
+		// Only the key images of the wallet's inputs are known
+		pubkey, _, err := db.GetKeyImage(value[i : i+keyImageSize])
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := db.restoreInput(b, pubkey); err != nil {
+			return err
+		}
+	}
+
+	if err := db.deletePendingTx(b, txID); err != nil {
+		return err
+	}
+
+	return db.storage.Write(b, writeOptions)
+}
+
+// deletePendingTx adds the deletion of the pending tx with id `txID`, and
+// of its record, to `b`.
+func (db *DB) deletePendingTx(b *leveldb.Batch, txID []byte) error {
+	b.Delete(pendingKey(txID))
+
+	txRecord, err := db.GetTxRecord(txID)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+
+	// A record which got confirmed in the meantime is kept
+	if err == nil && txRecord.State == txrecords.Pending {
+		b.Delete(txRecordKey(txID))
+		b.Delete(txHeightKey(txRecord.Height, txID))
+	}
+
+	return nil
+}
+
+// FetchPendingBalance returns the total amounts of the pending incoming
+// and outgoing txs.
+func (db *DB) FetchPendingBalance() (uint64, uint64, error) {
+	pending, err := db.FetchPendingTxs()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var incoming, outgoing uint64
+	for _, p := range pending {
+		txRecord, err := db.GetTxRecord(p.TxID)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if txRecord.Direction == txrecords.Out {
+			outgoing += txRecord.Amount
+			continue
+		}
+
+		incoming += txRecord.Amount
+	}
+
+	return incoming, outgoing, nil
+}
+
+func pendingKey(txID []byte) []byte {
+	key := make([]byte, 0, len(pendingPrefix)+len(txID))
+	key = append(key, pendingPrefix...)
+	return append(key, txID...)
+}
@@ -0,0 +1,352 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/dusk-network/dusk-wallet/v2/txrecords"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// pendingSpend is the spent height of inputs spent by a tx which is not
+// included in a block yet.
+const pendingSpend = math.MaxUint64
+
+// SpendInput removes the input belonging to the given one-time pubkey, which
+// was spent with `keyImage` in the block at `height`. The input is kept in
+// the spent archive, so that a rescan below `height` can restore it.
+func (db *DB) SpendInput(pubkey, keyImage []byte, height uint64) error {
+	b := new(leveldb.Batch)
+	b.Delete(append(keyImagePrefix, keyImage...))
+	if err := db.archiveInput(b, pubkey, keyImage, height); err != nil {
+		return err
+	}
+
+	return db.storage.Write(b, writeOptions)
+}
+
+// archiveInput moves the inputs belonging to `pubkey` to the spent archive.
+// Archived inputs which were spent by a pending tx are updated with the
+// height they were spent at.
+func (db *DB) archiveInput(b *leveldb.Batch, pubkey, keyImage []byte, height uint64) error {
+	// Schema
+	//
+	// key: spentPrefix + pubkey + nonce
+	// value: spent height + key image length + key image + encrypted input
+	for _, prefix := range [][]byte{inputPrefix, spentPrefix} {
+		key := make([]byte, 0, len(prefix)+len(pubkey))
+		key = append(key, prefix...)
+		key = append(key, pubkey...)
+
+		iter := db.storage.NewIterator(util.BytesPrefix(key), nil)
+		for iter.Next() {
+			input := iter.Value()
+			if prefix[0] == spentPrefix[0] {
+				var err error
+				_, _, input, err = decodeSpent(input)
+				if err != nil {
+					iter.Release()
+					return err
+				}
+			} else {
+				b.Delete(copyBytes(iter.Key()))
+			}
+
+			suffix := iter.Key()[len(prefix):]
+			b.Put(spentKey(suffix), encodeSpent(height, keyImage, input))
+		}
+
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreInput adds the writes moving the inputs belonging to `pubkey`
+// back from the spent archive to `b`, if a pending tx spent them.
+func (db *DB) restoreInput(b *leveldb.Batch, pubkey []byte) error {
+	iter := db.storage.NewIterator(util.BytesPrefix(spentKey(pubkey)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		spentHeight, _, input, err := decodeSpent(iter.Value())
+		if err != nil {
+			return err
+		}
+
+		if spentHeight != pendingSpend {
+			continue
+		}
+
+		inputKey := make([]byte, 0, len(inputPrefix)+len(iter.Key())-len(spentPrefix))
+		inputKey = append(inputKey, inputPrefix...)
+		inputKey = append(inputKey, iter.Key()[len(spentPrefix):]...)
+		b.Put(inputKey, copyBytes(input))
+		b.Delete(copyBytes(iter.Key()))
+	}
+
+	return iter.Error()
+}
+
+// Rescan removes everything learnt from the blocks at or above `height`,
+// and sets the wallet height to `height`, all at once. Outputs received from
+// `height` on are deleted, along with their key images, and outputs spent
+// from `height` on are restored. Outputs which unlocked at or above `height`
+// are locked again. The header hashes of the blocks are deleted as well.
+// Tx records from `height` on are deleted, including those of older wallet
+// versions. If the user attached a payment id or label to them, these are
+// kept, and restored once a record for the same tx is stored again.
+//
+// Outputs spent by pending txs stay spent.
+func (db *DB) Rescan(decryptionKey []byte, height uint64) error {
+	b := new(leveldb.Batch)
+
+	// One-time pubkeys of the outputs received from `height` on
+	removed := make(map[string]bool)
+
+	iter := db.storage.NewIterator(util.BytesPrefix(inputPrefix), nil)
+	for iter.Next() {
+		idb, err := decodeInput(iter.Value(), decryptionKey)
+		if err != nil {
+			iter.Release()
+			return err
+		}
+
+		if idb.height >= height {
+			// key: inputPrefix + pubkey + nonce
+			removed[string(iter.Key()[len(inputPrefix):len(iter.Key())-8])] = true
+			b.Delete(copyBytes(iter.Key()))
+			continue
+		}
+
+		if idb.relock(height) {
+			value, err := encodeInput(idb, decryptionKey)
+			if err != nil {
+				iter.Release()
+				return err
+			}
+			b.Put(copyBytes(iter.Key()), value)
+		}
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	iter = db.storage.NewIterator(util.BytesPrefix(spentPrefix), nil)
+	for iter.Next() {
+		spentHeight, keyImage, input, err := decodeSpent(iter.Value())
+		if err != nil {
+			iter.Release()
+			return err
+		}
+
+		idb, err := decodeInput(input, decryptionKey)
+		if err != nil {
+			iter.Release()
+			return err
+		}
+
+		// key: spentPrefix + pubkey + nonce
+		suffix := iter.Key()[len(spentPrefix):]
+		pubkey := suffix[:len(suffix)-8]
+		switch {
+		case idb.height >= height:
+			removed[string(pubkey)] = true
+			b.Delete(copyBytes(iter.Key()))
+		case spentHeight >= height && spentHeight != pendingSpend:
+			if idb.relock(height) {
+				if input, err = encodeInput(idb, decryptionKey); err != nil {
+					iter.Release()
+					return err
+				}
+			}
+
+			inputKey := make([]byte, 0, len(inputPrefix)+len(suffix))
+			inputKey = append(inputKey, inputPrefix...)
+			inputKey = append(inputKey, suffix...)
+			b.Put(inputKey, copyBytes(input))
+
+			keyImageValue := make([]byte, len(pubkey)+8)
+			copy(keyImageValue, pubkey)
+			binary.LittleEndian.PutUint64(keyImageValue[len(pubkey):], idb.amount.BigInt().Uint64())
+			b.Put(append(copyBytes(keyImagePrefix), keyImage...), keyImageValue)
+			b.Delete(copyBytes(iter.Key()))
+		}
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	iter = db.storage.NewIterator(util.BytesPrefix(keyImagePrefix), nil)
+	for iter.Next() {
+		if len(iter.Value()) >= 32 && removed[string(iter.Value()[:32])] {
+			b.Delete(copyBytes(iter.Key()))
+		}
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	iter = db.storage.NewIterator(&util.Range{
+		Start: headerKey(height),
+		Limit: util.BytesPrefix(headerPrefix).Limit,
+	}, nil)
+	for iter.Next() {
+		b.Delete(copyBytes(iter.Key()))
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	records, err := db.FetchTxRecordsFromHeight(height)
+	if err != nil {
+		return err
+	}
+
+	for i := range records {
+		txRecord := &records[i]
+		if txRecord.State == txrecords.Pending {
+			continue
+		}
+
+		b.Delete(txRecordKey(txRecord.TxID))
+		b.Delete(txHeightKey(txRecord.Height, txRecord.TxID))
+		if len(txRecord.PaymentID) > 0 || txRecord.Label != "" || len(txRecord.Counterparties) > 0 {
+			putTxNote(b, txRecord)
+		}
+	}
+
+	if err := db.deleteLegacyTxRecords(b, height); err != nil {
+		return err
+	}
+
+	heightBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(heightBytes, height)
+	b.Put(walletHeightPrefix, heightBytes)
+	return db.storage.Write(b, writeOptions)
+}
+
+var errInvalidTxNote = errors.New("invalid tx note")
+
+// putTxNote adds the writes keeping the payment id, label and
+// counterparties of `txRecord` to `b`.
+func putTxNote(b *leveldb.Batch, txRecord *txrecords.TxRecord) {
+	// Schema
+	//
+	// key: notePrefix + txid
+	// value: payment id length + payment id + label length (2) + label +
+	//        (counterparty length + counterparty)...
+	value := make([]byte, 0, 3+len(txRecord.PaymentID)+len(txRecord.Label))
+	value = append(value, uint8(len(txRecord.PaymentID)))
+	value = append(value, txRecord.PaymentID...)
+	value = append(value, 0, 0)
+	binary.LittleEndian.PutUint16(value[len(value)-2:], uint16(len(txRecord.Label)))
+	value = append(value, txRecord.Label...)
+	for _, counterparty := range txRecord.Counterparties {
+		value = append(value, uint8(len(counterparty)))
+		value = append(value, counterparty...)
+	}
+
+	b.Put(noteKey(txRecord.TxID), value)
+}
+
+// restoreTxNote sets the payment id, label and counterparties kept for
+// `txRecord` by a rescan, unless the record has its own, and adds the
+// deletion of the note to `b`.
+func (db *DB) restoreTxNote(b *leveldb.Batch, txRecord *txrecords.TxRecord) error {
+	value, err := db.storage.Get(noteKey(txRecord.TxID), nil)
+	if err == leveldb.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(value) < 1+int(value[0])+2 {
+		return errInvalidTxNote
+	}
+
+	paymentID := value[1 : 1+int(value[0])]
+	value = value[1+int(value[0]):]
+	lenLabel := int(binary.LittleEndian.Uint16(value))
+	if len(value) < 2+lenLabel {
+		return errInvalidTxNote
+	}
+
+	label := string(value[2 : 2+lenLabel])
+	value = value[2+lenLabel:]
+
+	var counterparties []string
+	for len(value) > 0 {
+		if len(value) < 1+int(value[0]) {
+			return errInvalidTxNote
+		}
+
+		counterparties = append(counterparties, string(value[1:1+int(value[0])]))
+		value = value[1+int(value[0]):]
+	}
+
+	if len(txRecord.PaymentID) == 0 && len(paymentID) > 0 {
+		txRecord.PaymentID = copyBytes(paymentID)
+	}
+
+	if txRecord.Label == "" {
+		txRecord.Label = label
+	}
+
+	if len(txRecord.Counterparties) == 0 {
+		txRecord.Counterparties = counterparties
+	}
+
+	b.Delete(noteKey(txRecord.TxID))
+	return nil
+}
+
+func noteKey(txID []byte) []byte {
+	key := make([]byte, 0, len(notePrefix)+len(txID))
+	key = append(key, notePrefix...)
+	return append(key, txID...)
+}
+
+func spentKey(suffix []byte) []byte {
+	key := make([]byte, 0, len(spentPrefix)+len(suffix))
+	key = append(key, spentPrefix...)
+	return append(key, suffix...)
+}
+
+func encodeSpent(height uint64, keyImage, input []byte) []byte {
+	value := make([]byte, 9, 9+len(keyImage)+len(input))
+	binary.LittleEndian.PutUint64(value, height)
+	value[8] = uint8(len(keyImage))
+	value = append(value, keyImage...)
+	return append(value, input...)
+}
+
+func decodeSpent(value []byte) (uint64, []byte, []byte, error) {
+	if len(value) < 9 || len(value) < 9+int(value[8]) {
+		return 0, nil, nil, errors.New("invalid spent input entry")
+	}
+
+	height := binary.LittleEndian.Uint64(value)
+	keyImage := copyBytes(value[9 : 9+int(value[8])])
+	return height, keyImage, copyBytes(value[9+int(value[8]):]), nil
+}
+
+func copyBytes(bs []byte) []byte {
+	c := make([]byte, len(bs))
+	copy(c, bs)
+	return c
+}
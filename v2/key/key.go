@@ -60,11 +60,23 @@ func (k Key) PrivateSpend() (*PrivateSpend, error) {
 // and the tx pubkey R
 // checks whether the tx was intended for the key assosciated
 func (k *Key) DidReceiveTx(R ristretto.Point, stealth StealthAddress, index uint32) (*ristretto.Scalar, bool) {
+	return k.DidReceiveTxWithSecret(k.SharedSecret(R), stealth, index)
+}
 
-	pubKey := k.PublicKey()
-
+// SharedSecret returns R * privView, the secret shared with the sender of
+// the tx with pubkey R. It is the same for all outputs of the tx, so when
+// scanning a tx it only needs to be computed once.
+func (k *Key) SharedSecret(R ristretto.Point) ristretto.Point {
 	var Dprime ristretto.Point
 	Dprime.ScalarMult(&R, k.privKey.privView.scalar())
+	return Dprime
+}
+
+// DidReceiveTxWithSecret is like DidReceiveTx, but takes the shared secret
+// of the tx, as returned by SharedSecret, instead of its pubkey.
+func (k *Key) DidReceiveTxWithSecret(Dprime ristretto.Point, stealth StealthAddress, index uint32) (*ristretto.Scalar, bool) {
+
+	pubKey := k.PublicKey()
 
 	var fprime ristretto.Scalar
 	DprimeIndex := concatSlice(Dprime.Bytes(), uint32ToBytes(index))
@@ -37,4 +37,12 @@ func TestDidReceive(t *testing.T) {
 
 	assert.True(t, expectedPubKey0.Equals(&pubKey0.P))
 	assert.True(t, expectedPubKey1.Equals(&pubKey1.P))
+
+	// The shared secret gives the same result
+	secret := k.SharedSecret(R)
+	privKey, ok := k.DidReceiveTxWithSecret(secret, *pubKey1, 1)
+	assert.True(t, ok)
+	assert.True(t, privKey.Equals(privKey1))
+	_, ok = k.DidReceiveTxWithSecret(secret, *pubKey1, 0)
+	assert.False(t, ok)
 }
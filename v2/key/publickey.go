@@ -98,6 +98,14 @@ func (k *PublicKey) StealthAddress(r ristretto.Scalar, index uint32) *StealthAdd
 	return &StealthAddress{P: P}
 }
 
+// ViewTag returns the view tag of the output at `index` of a tx with
+// nonce r, sent to this public key.
+func (k *PublicKey) ViewTag(r ristretto.Scalar, index uint32) uint8 {
+	var rA ristretto.Point
+	rA.ScalarMult(k.PubView.point(), &r)
+	return ViewTag(rA, index)
+}
+
 // PublicAddress will return the base58 encoded public address
 // The stealth addresses are referred to as the one time
 // addresses derived when a user wants to send funds to another user
@@ -1,8 +1,26 @@
 package key
 
-import ristretto "github.com/bwesterb/go-ristretto"
+import (
+	ristretto "github.com/bwesterb/go-ristretto"
+	"golang.org/x/crypto/sha3"
+)
 
 // StealthAddress represents a Dusk stealth adress
 type StealthAddress struct {
 	P ristretto.Point
 }
+
+// viewTagDomain separates the view tag hash from the other hashes of the
+// shared secret.
+var viewTagDomain = []byte("view_tag")
+
+// ViewTag returns the view tag of the output at `index` of a tx with
+// shared secret D = r*PubView = R*privView. It is the first byte of
+// H("view_tag" || D || index).
+//
+// A receiver whose view tag does not match can skip the output without
+// deriving its stealth address. Only 1 in 256 foreign outputs will match.
+func ViewTag(D ristretto.Point, index uint32) uint8 {
+	h := sha3.Sum256(concatSlice(viewTagDomain, D.Bytes(), uint32ToBytes(index)))
+	return h[0]
+}
@@ -44,7 +44,7 @@ func NewCoinbase(proof, score []byte, netPrefix byte) *Coinbase {
 }
 
 func (c *Coinbase) AddReward(pubKey key.PublicKey, amount ristretto.Scalar) error {
-	if len(c.Rewards)+1 > maxOutputs {
+	if len(c.Rewards)+1 > MaxOutputs {
 		return errors.New("maximum amount of outputs reached")
 	}
 
@@ -140,8 +140,9 @@ func marshalCoinbase(b *bytes.Buffer, c *Coinbase) error {
 		return err
 	}
 
+	// Coinbase txs are not versioned, and carry no view tags
 	for _, output := range c.Rewards {
-		if err := marshalOutput(b, output); err != nil {
+		if err := marshalOutput(b, output, 0); err != nil {
 			return err
 		}
 	}
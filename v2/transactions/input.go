@@ -55,6 +55,11 @@ func NewInput(amount, mask, privkey ristretto.Scalar) *Input {
 	return i
 }
 
+// Amount returns the amount of DUSK held by this input
+func (i *Input) Amount() ristretto.Scalar {
+	return i.amount
+}
+
 func (i *Input) setPseudoComm(x ristretto.Point) {
 	i.PseudoCommitment = x
 }
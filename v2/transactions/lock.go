@@ -0,0 +1,79 @@
+package transactions
+
+// LockReason tells why an output is locked.
+type LockReason uint8
+
+const (
+	// LockNone is used for outputs which can be spent straight away
+	LockNone LockReason = iota
+	// LockStake is used for the amount locked up by a stake
+	LockStake
+	// LockBid is used for the amount locked up by a blind bid
+	LockBid
+	// LockTimelock is used for outputs paid by a time-locked transaction
+	LockTimelock
+	// LockCoinbase is used for coinbase rewards which did not mature yet
+	LockCoinbase
+	// LockConfirmations is used for outputs which are not buried under
+	// enough blocks yet to be spent
+	LockConfirmations
+)
+
+func (l LockReason) String() string {
+	switch l {
+	case LockNone:
+		return "none"
+	case LockStake:
+		return "stake"
+	case LockBid:
+		return "bid"
+	case LockTimelock:
+		return "timelock"
+	case LockCoinbase:
+		return "coinbase maturity"
+	case LockConfirmations:
+		return "confirmations"
+	default:
+		return "unknown"
+	}
+}
+
+// OutputRole describes the purpose of an output within its transaction.
+type OutputRole uint8
+
+const (
+	// PaymentOutput is an output paying the recipient of the transaction
+	PaymentOutput OutputRole = iota
+	// ChangeOutput is an output returning change to the sender
+	ChangeOutput
+)
+
+// OutputLock returns why, and for how many blocks after inclusion, the
+// output at `index` of `tx` is locked.
+//
+// Stakes and bids lock up their first output, which holds the staked or bid
+// amount. Timelocks lock up everything they pay out, but not their change.
+// Coinbase rewards need to mature for `coinbaseMaturity` blocks, which is
+// set by the network, before they can be spent.
+func OutputLock(tx Transaction, index uint32, role OutputRole, coinbaseMaturity uint64) (LockReason, uint64) {
+	switch tx.Type() {
+	case CoinbaseType:
+		if coinbaseMaturity > 0 {
+			return LockCoinbase, coinbaseMaturity
+		}
+	case StakeType:
+		if index == 0 {
+			return LockStake, tx.LockTime()
+		}
+	case BidType:
+		if index == 0 {
+			return LockBid, tx.LockTime()
+		}
+	case TimelockType:
+		if role == PaymentOutput {
+			return LockTimelock, tx.LockTime()
+		}
+	}
+
+	return LockNone, 0
+}
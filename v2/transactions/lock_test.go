@@ -0,0 +1,49 @@
+package transactions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputLock(t *testing.T) {
+	standard, err := NewStandard(0, 1, 100)
+	assert.NoError(t, err)
+	timelock, err := NewTimelock(0, 1, 100, 1000)
+	assert.NoError(t, err)
+	stake, err := NewStake(0, 1, 100, 2000, make([]byte, 32), make([]byte, 129))
+	assert.NoError(t, err)
+	bid, err := NewBid(0, 1, 100, 3000, make([]byte, 32))
+	assert.NoError(t, err)
+	coinbase := NewCoinbase(make([]byte, 100), make([]byte, 32), 1)
+
+	tests := []struct {
+		tx       Transaction
+		index    uint32
+		role     OutputRole
+		reason   LockReason
+		lockTime uint64
+	}{
+		{standard, 0, PaymentOutput, LockNone, 0},
+		{timelock, 0, PaymentOutput, LockTimelock, 1000},
+		{timelock, 1, PaymentOutput, LockTimelock, 1000},
+		{timelock, 1, ChangeOutput, LockNone, 0},
+		{stake, 0, PaymentOutput, LockStake, 2000},
+		{stake, 1, PaymentOutput, LockNone, 0},
+		{bid, 0, PaymentOutput, LockBid, 3000},
+		{bid, 1, ChangeOutput, LockNone, 0},
+		{coinbase, 0, PaymentOutput, LockCoinbase, 60},
+		{coinbase, 3, PaymentOutput, LockCoinbase, 60},
+	}
+
+	for _, test := range tests {
+		reason, lockTime := OutputLock(test.tx, test.index, test.role, 60)
+		assert.Equal(t, test.reason, reason)
+		assert.Equal(t, test.lockTime, lockTime)
+	}
+
+	// Without a maturity, coinbase rewards are not locked
+	reason, lockTime := OutputLock(coinbase, 0, PaymentOutput, 0)
+	assert.Equal(t, LockNone, reason)
+	assert.Equal(t, uint64(0), lockTime)
+}
@@ -0,0 +1,403 @@
+package transactions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/dusk-network/dusk-crypto/mlsag"
+)
+
+// ErrUnsignedInput is returned when marshaling a tx which was not proven yet
+var ErrUnsignedInput = errors.New("cannot marshal an unsigned input")
+
+// Marshal writes `tx` to `b` in the block file format of the wallet. Unlike
+// the encoding used for the tx hash, it carries the signature of every
+// input, and prefixes the coinbase rewards with their count. Integers are
+// little endian, and counts and lengths are VarInts:
+//
+//	standard: type | R | version | input count | inputs | output count |
+//	          outputs | fee (8) | range proof length | range proof
+//	input:    key image | pubkey | pseudo commitment | signature length |
+//	          signature
+//	output:   commitment | pubkey | encrypted amount | encrypted mask |
+//	          view tag (1, from ViewTagVersion on)
+//	timelock: standard | lock (8)
+//	bid:      timelock | M (32)
+//	stake:    timelock | ed25519 pubkey (32) | BLS pubkey length | BLS pubkey
+//	coinbase: type | R | score (32) | proof length | proof | reward count |
+//	          rewards
+//
+// Points and scalars take 32 bytes.
+func Marshal(b *bytes.Buffer, tx Transaction) error {
+	switch t := tx.(type) {
+	case *Coinbase:
+		return marshalWireCoinbase(b, t)
+	case *Standard:
+		return marshalWireStandard(b, t)
+	case *Timelock:
+		return marshalWireTimelock(b, t)
+	case *Bid:
+		if len(t.M) != 32 {
+			return fmt.Errorf("bid M has %d bytes instead of 32", len(t.M))
+		}
+
+		if err := marshalWireTimelock(b, t.Timelock); err != nil {
+			return err
+		}
+		return binary.Write(b, binary.BigEndian, t.M)
+	case *Stake:
+		if len(t.PubKeyEd) != 32 {
+			return fmt.Errorf("stake ed25519 key has %d bytes instead of 32", len(t.PubKeyEd))
+		}
+
+		if err := marshalWireTimelock(b, t.Timelock); err != nil {
+			return err
+		}
+
+		if err := binary.Write(b, binary.BigEndian, t.PubKeyEd); err != nil {
+			return err
+		}
+		return writeVarBytes(b, t.PubKeyBLS)
+	default:
+		return fmt.Errorf("cannot marshal tx of type %s", tx.Type())
+	}
+}
+
+// Unmarshal reads a tx written by Marshal from `b`. The message of the input
+// signatures is not part of the block file format, and is left empty.
+func Unmarshal(b *bytes.Buffer) (Transaction, error) {
+	var txType TxType
+	if err := binary.Read(b, binary.LittleEndian, &txType); err != nil {
+		return nil, err
+	}
+
+	switch txType {
+	case CoinbaseType:
+		c := &Coinbase{TxType: txType}
+		if err := unmarshalWireCoinbase(b, c); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case StandardType:
+		s := &Standard{TxType: txType}
+		if err := unmarshalWireStandard(b, s); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case TimelockType:
+		return unmarshalWireTimelock(b, txType)
+	case BidType:
+		tl, err := unmarshalWireTimelock(b, txType)
+		if err != nil {
+			return nil, err
+		}
+
+		bid := &Bid{Timelock: tl, M: make([]byte, 32)}
+		if _, err := io.ReadFull(b, bid.M); err != nil {
+			return nil, err
+		}
+		return bid, nil
+	case StakeType:
+		tl, err := unmarshalWireTimelock(b, txType)
+		if err != nil {
+			return nil, err
+		}
+
+		stake := &Stake{Timelock: tl, PubKeyEd: make([]byte, 32)}
+		if _, err := io.ReadFull(b, stake.PubKeyEd); err != nil {
+			return nil, err
+		}
+
+		if stake.PubKeyBLS, err = readVarBytes(b); err != nil {
+			return nil, err
+		}
+		return stake, nil
+	default:
+		return nil, fmt.Errorf("cannot unmarshal tx of type %s", txType)
+	}
+}
+
+func marshalWireStandard(b *bytes.Buffer, tx *Standard) error {
+	if err := binary.Write(b, binary.LittleEndian, tx.TxType); err != nil {
+		return err
+	}
+
+	if err := binary.Write(b, binary.BigEndian, tx.R.Bytes()); err != nil {
+		return err
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, tx.Version); err != nil {
+		return err
+	}
+
+	if err := writeVarInt(b, uint64(len(tx.Inputs))); err != nil {
+		return err
+	}
+
+	for _, input := range tx.Inputs {
+		if input.Signature == nil {
+			return ErrUnsignedInput
+		}
+
+		if err := marshalInput(b, input); err != nil {
+			return err
+		}
+
+		sigBuf := new(bytes.Buffer)
+		if err := input.Signature.Encode(sigBuf, false); err != nil {
+			return err
+		}
+
+		if err := writeVarBytes(b, sigBuf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if err := writeVarInt(b, uint64(len(tx.Outputs))); err != nil {
+		return err
+	}
+
+	for _, output := range tx.Outputs {
+		if err := marshalOutput(b, output, tx.Version); err != nil {
+			return err
+		}
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, tx.Fee.BigInt().Uint64()); err != nil {
+		return err
+	}
+
+	rpBuf := new(bytes.Buffer)
+	if err := tx.RangeProof.Encode(rpBuf, true); err != nil {
+		return err
+	}
+
+	return writeVarBytes(b, rpBuf.Bytes())
+}
+
+// unmarshalWireStandard reads a standard tx, whose type was already read
+// from `b`.
+func unmarshalWireStandard(b *bytes.Buffer, tx *Standard) error {
+	var R [32]byte
+	if _, err := io.ReadFull(b, R[:]); err != nil {
+		return err
+	}
+	tx.R.SetBytes(&R)
+
+	if err := binary.Read(b, binary.LittleEndian, &tx.Version); err != nil {
+		return err
+	}
+
+	lenInputs, err := ReadVarInt(b)
+	if err != nil {
+		return err
+	}
+
+	if lenInputs > MaxInputs {
+		return fmt.Errorf("tx has %d inputs, the maximum is %d", lenInputs, MaxInputs)
+	}
+
+	tx.Inputs = make(Inputs, lenInputs)
+	for i := range tx.Inputs {
+		input := &Input{Signature: &mlsag.Signature{}}
+		var keyImage, pubKey, pseudoComm [32]byte
+		for _, field := range [][]byte{keyImage[:], pubKey[:], pseudoComm[:]} {
+			if _, err := io.ReadFull(b, field); err != nil {
+				return err
+			}
+		}
+
+		input.KeyImage.SetBytes(&keyImage)
+		input.PubKey.P.SetBytes(&pubKey)
+		input.PseudoCommitment.SetBytes(&pseudoComm)
+
+		sig, err := readVarBytes(b)
+		if err != nil {
+			return err
+		}
+
+		if err := input.Signature.Decode(bytes.NewBuffer(sig), false); err != nil {
+			return err
+		}
+
+		tx.Inputs[i] = input
+	}
+
+	if tx.Outputs, err = unmarshalOutputs(b, tx.Version); err != nil {
+		return err
+	}
+
+	var fee uint64
+	if err := binary.Read(b, binary.LittleEndian, &fee); err != nil {
+		return err
+	}
+	tx.Fee.SetBigInt(new(big.Int).SetUint64(fee))
+
+	rp, err := readVarBytes(b)
+	if err != nil {
+		return err
+	}
+
+	return tx.RangeProof.Decode(bytes.NewBuffer(rp), true)
+}
+
+func marshalWireTimelock(b *bytes.Buffer, tl *Timelock) error {
+	if err := marshalWireStandard(b, tl.Standard); err != nil {
+		return err
+	}
+
+	return binary.Write(b, binary.LittleEndian, tl.Lock)
+}
+
+func unmarshalWireTimelock(b *bytes.Buffer, txType TxType) (*Timelock, error) {
+	tl := &Timelock{Standard: &Standard{TxType: txType}}
+	if err := unmarshalWireStandard(b, tl.Standard); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Read(b, binary.LittleEndian, &tl.Lock); err != nil {
+		return nil, err
+	}
+
+	return tl, nil
+}
+
+func marshalWireCoinbase(b *bytes.Buffer, c *Coinbase) error {
+	if err := binary.Write(b, binary.LittleEndian, c.TxType); err != nil {
+		return err
+	}
+
+	if err := binary.Write(b, binary.BigEndian, c.R.Bytes()); err != nil {
+		return err
+	}
+
+	if len(c.Score) != 32 {
+		return fmt.Errorf("coinbase score has %d bytes instead of 32", len(c.Score))
+	}
+
+	if err := binary.Write(b, binary.BigEndian, c.Score); err != nil {
+		return err
+	}
+
+	if err := writeVarBytes(b, c.Proof); err != nil {
+		return err
+	}
+
+	if err := writeVarInt(b, uint64(len(c.Rewards))); err != nil {
+		return err
+	}
+
+	for _, output := range c.Rewards {
+		if err := marshalOutput(b, output, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unmarshalWireCoinbase(b *bytes.Buffer, c *Coinbase) error {
+	var R [32]byte
+	if _, err := io.ReadFull(b, R[:]); err != nil {
+		return err
+	}
+	c.R.SetBytes(&R)
+
+	c.Score = make([]byte, 32)
+	if _, err := io.ReadFull(b, c.Score); err != nil {
+		return err
+	}
+
+	var err error
+	if c.Proof, err = readVarBytes(b); err != nil {
+		return err
+	}
+
+	c.Rewards, err = unmarshalOutputs(b, 0)
+	return err
+}
+
+func unmarshalOutputs(b *bytes.Buffer, version uint8) (Outputs, error) {
+	lenOutputs, err := ReadVarInt(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if lenOutputs > MaxOutputs {
+		return nil, fmt.Errorf("tx has %d outputs, the maximum is %d", lenOutputs, MaxOutputs)
+	}
+
+	outputs := make(Outputs, lenOutputs)
+	for i := range outputs {
+		output := &Output{Index: uint32(i)}
+		if err := unmarshalOutput(b, output, version); err != nil {
+			return nil, err
+		}
+		outputs[i] = output
+	}
+
+	return outputs, nil
+}
+
+// WriteVarInt writes `v` to `b` as a VarInt, like the encoding used for the
+// tx hash does.
+func WriteVarInt(b *bytes.Buffer, v uint64) error {
+	return writeVarInt(b, v)
+}
+
+// ReadVarInt reads a VarInt written by WriteVarInt from `b`.
+func ReadVarInt(b *bytes.Buffer) (uint64, error) {
+	var prefix uint8
+	if err := binary.Read(b, binary.LittleEndian, &prefix); err != nil {
+		return 0, err
+	}
+
+	switch prefix {
+	case 0xfd:
+		var v uint16
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return uint64(v), err
+	case 0xfe:
+		var v uint32
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return uint64(v), err
+	case 0xff:
+		var v uint64
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	default:
+		return uint64(prefix), nil
+	}
+}
+
+func writeVarBytes(b *bytes.Buffer, bs []byte) error {
+	if err := writeVarInt(b, uint64(len(bs))); err != nil {
+		return err
+	}
+
+	_, err := b.Write(bs)
+	return err
+}
+
+func readVarBytes(b *bytes.Buffer) ([]byte, error) {
+	l, err := ReadVarInt(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't allocate more than the buffer can hold
+	if l > uint64(b.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	bs := make([]byte, l)
+	if _, err := io.ReadFull(b, bs); err != nil {
+		return nil, err
+	}
+
+	return bs, nil
+}
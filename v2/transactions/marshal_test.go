@@ -0,0 +1,128 @@
+package transactions
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dusk-network/dusk-wallet/v2/key"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshal(t *testing.T) {
+	netPrefix := byte(1)
+
+	standard, err := NewStandard(0, netPrefix, 100)
+	assert.NoError(t, err)
+	viewTagged, err := NewStandard(ViewTagVersion, netPrefix, 100)
+	assert.NoError(t, err)
+	timelock, err := NewTimelock(0, netPrefix, 100, 1000)
+	assert.NoError(t, err)
+	bid, err := NewBid(0, netPrefix, 100, 2000, randomSlice(32))
+	assert.NoError(t, err)
+	stake, err := NewStake(0, netPrefix, 100, 3000, randomSlice(32), randomSlice(129))
+	assert.NoError(t, err)
+
+	txs := []Transaction{standard, viewTagged, timelock, bid, stake, randomCoinbase(t, netPrefix, 2)}
+	for _, tx := range txs[:5] {
+		proveTx(t, tx, netPrefix)
+	}
+
+	for _, tx := range txs {
+		buf := new(bytes.Buffer)
+		assert.NoError(t, Marshal(buf, tx))
+		encoded := append([]byte{}, buf.Bytes()...)
+
+		decoded, err := Unmarshal(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, buf.Len())
+		assert.Equal(t, tx.Type(), decoded.Type())
+
+		// The signature messages are not encoded, so the decoded tx is
+		// compared by its encoding and hash
+		reencoded := new(bytes.Buffer)
+		assert.NoError(t, Marshal(reencoded, decoded))
+		assert.Equal(t, encoded, reencoded.Bytes())
+
+		expected, err := tx.CalculateHash()
+		assert.NoError(t, err)
+		actual, err := decoded.CalculateHash()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+
+		// Truncated txs can not be decoded
+		_, err = Unmarshal(bytes.NewBuffer(encoded[:len(encoded)-1]))
+		assert.Error(t, err)
+	}
+}
+
+func TestMarshalLayout(t *testing.T) {
+	netPrefix := byte(1)
+
+	// type | R | score | proof length | proof | reward count | rewards
+	coinbase := randomCoinbase(t, netPrefix, 2)
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Marshal(buf, coinbase))
+	assert.Equal(t, 1+32+32+1+len(coinbase.Proof)+1+2*4*32, buf.Len())
+	assert.Equal(t, uint8(2), buf.Bytes()[1+32+32+1+len(coinbase.Proof)])
+
+	// Outputs of versioned txs carry a view tag
+	sizes := make([]int, 2)
+	for i, version := range []uint8{0, ViewTagVersion} {
+		tx, err := NewStandard(version, netPrefix, 100)
+		assert.NoError(t, err)
+		proveTx(t, tx, netPrefix)
+
+		buf := new(bytes.Buffer)
+		assert.NoError(t, Marshal(buf, tx))
+		sizes[i] = buf.Len()
+	}
+	assert.Equal(t, sizes[0]+2, sizes[1])
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	netPrefix := byte(1)
+
+	// Inputs need a signature
+	tx, err := NewStandard(0, netPrefix, 100)
+	assert.NoError(t, err)
+	addValueInputToTx(30, tx)
+	assert.Equal(t, ErrUnsignedInput, Marshal(new(bytes.Buffer), tx))
+
+	// Fixed size fields are not padded
+	bid, err := NewBid(0, netPrefix, 100, 2000, randomSlice(31))
+	assert.NoError(t, err)
+	proveTx(t, bid, netPrefix)
+	assert.Error(t, Marshal(new(bytes.Buffer), bid))
+
+	coinbase := randomCoinbase(t, netPrefix, 1)
+	coinbase.Score = randomSlice(31)
+	assert.Error(t, Marshal(new(bytes.Buffer), coinbase))
+
+	// Unknown tx types can not be decoded
+	_, err = Unmarshal(bytes.NewBuffer([]byte{0xff}))
+	assert.Error(t, err)
+}
+
+// proveTx adds an input and two outputs to `tx`, and proves it.
+func proveTx(t *testing.T, tx Transaction, netPrefix byte) {
+	s := tx.StandardTx()
+	addValueInputToTx(60, s)
+	assert.NoError(t, s.AddDecoys(minDecoys, generateDecoys))
+	addValueOutputToTx(t, 30, netPrefix, s)
+	addValueOutputToTx(t, 30, netPrefix, s)
+
+	prover, ok := tx.(interface{ Prove() error })
+	assert.True(t, ok)
+	assert.NoError(t, prover.Prove())
+}
+
+func randomCoinbase(t *testing.T, netPrefix byte, rewards int) *Coinbase {
+	coinbase := NewCoinbase(randomSlice(100), randomSlice(32), netPrefix)
+	for i := 0; i < rewards; i++ {
+		pubKey := key.NewKeyPair([]byte{byte(i)}).PublicKey()
+		assert.NoError(t, coinbase.AddReward(*pubKey, int64ToScalar(int64(100*(i+1)))))
+	}
+
+	return coinbase
+}
@@ -31,8 +31,20 @@ type Output struct {
 	viewKey         key.PublicView
 	EncryptedAmount ristretto.Scalar
 	EncryptedMask   ristretto.Scalar
+
+	// ViewTag lets receivers skip outputs which are not theirs cheaply. It
+	// is only encoded in txs of version ViewTagVersion and above
+	ViewTag uint8
+
+	// address is the public address the output pays to. It is not encoded,
+	// so only the creator of the tx knows it
+	address key.PublicAddress
 }
 
+// ViewTagVersion is the first tx version which carries view tags in its
+// outputs.
+const ViewTagVersion uint8 = 1
+
 func NewOutput(r, amount ristretto.Scalar, index uint32, pubKey key.PublicKey) *Output {
 	output := &Output{
 		amount:  amount,
@@ -44,6 +56,12 @@ func NewOutput(r, amount ristretto.Scalar, index uint32, pubKey key.PublicKey) *
 	return output
 }
 
+// Address returns the public address the output was created for, or an
+// empty address if the output was decoded.
+func (o *Output) Address() key.PublicAddress {
+	return o.address
+}
+
 func sliceToPoint(b []byte) ristretto.Point {
 	var bBytes [32]byte
 	copy(bBytes[:], b)
@@ -86,6 +104,12 @@ func DecryptAmount(encAmount ristretto.Scalar, R ristretto.Point, index uint32,
 	pv := (ristretto.Scalar)(privViewKey)
 	Rview.ScalarMult(&R, &pv)
 
+	return DecryptAmountWithSecret(encAmount, Rview, index)
+}
+
+// DecryptAmountWithSecret is like DecryptAmount, but takes the shared
+// secret R*PrivViewKey of the tx, as returned by key.Key.SharedSecret.
+func DecryptAmountWithSecret(encAmount ristretto.Scalar, Rview ristretto.Point, index uint32) ristretto.Scalar {
 	rViewIndex := append(Rview.Bytes(), uint32ToBytes(index)...)
 
 	var encryptKey ristretto.Scalar
@@ -120,6 +144,12 @@ func DecryptMask(encMask ristretto.Scalar, R ristretto.Point, index uint32, priv
 	pv := (ristretto.Scalar)(privViewKey)
 	Rview.ScalarMult(&R, &pv)
 
+	return DecryptMaskWithSecret(encMask, Rview, index)
+}
+
+// DecryptMaskWithSecret is like DecryptMask, but takes the shared secret
+// R*PrivViewKey of the tx, as returned by key.Key.SharedSecret.
+func DecryptMaskWithSecret(encMask ristretto.Scalar, Rview ristretto.Point, index uint32) ristretto.Scalar {
 	rViewIndex := append(Rview.Bytes(), uint32ToBytes(index)...)
 
 	var encryptKey ristretto.Scalar
@@ -159,7 +189,7 @@ func (o *Output) Equals(out *Output) bool {
 	return bytes.Equal(o.EncryptedMask.Bytes(), out.EncryptedMask.Bytes())
 }
 
-func marshalOutput(b *bytes.Buffer, o *Output) error {
+func marshalOutput(b *bytes.Buffer, o *Output, version uint8) error {
 	if err := binary.Write(b, binary.BigEndian, o.Commitment.Bytes()); err != nil {
 		return err
 	}
@@ -176,5 +206,31 @@ func marshalOutput(b *bytes.Buffer, o *Output) error {
 		return err
 	}
 
+	if version >= ViewTagVersion {
+		if err := binary.Write(b, binary.LittleEndian, o.ViewTag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unmarshalOutput(b *bytes.Buffer, o *Output, version uint8) error {
+	var commitment, pubKey, encAmount, encMask [32]byte
+	for _, field := range [][]byte{commitment[:], pubKey[:], encAmount[:], encMask[:]} {
+		if err := binary.Read(b, binary.BigEndian, field); err != nil {
+			return err
+		}
+	}
+
+	o.Commitment.SetBytes(&commitment)
+	o.PubKey.P.SetBytes(&pubKey)
+	o.EncryptedAmount.SetBytes(&encAmount)
+	o.EncryptedMask.SetBytes(&encMask)
+
+	if version >= ViewTagVersion {
+		return binary.Read(b, binary.LittleEndian, &o.ViewTag)
+	}
+
 	return nil
 }
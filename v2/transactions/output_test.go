@@ -1,6 +1,7 @@
 package transactions
 
 import (
+	"bytes"
 	"math/rand"
 	"testing"
 
@@ -65,3 +66,49 @@ func TestEncryptionMask(t *testing.T) {
 
 	assert.Equal(t, decryptedMask, mask)
 }
+
+func TestViewTag(t *testing.T) {
+	var amount ristretto.Scalar
+	amount.Rand()
+	keyPair := key.NewKeyPair([]byte("seed for test"))
+	addr, err := keyPair.PublicKey().PublicAddress(1)
+	assert.NoError(t, err)
+
+	// Only txs from the view tag version on tag their outputs
+	untagged, err := NewStandard(0, 1, 100)
+	assert.NoError(t, err)
+	assert.NoError(t, untagged.AddOutput(*addr, amount))
+	assert.Equal(t, uint8(0), untagged.Outputs[0].ViewTag)
+
+	tx, err := NewStandard(ViewTagVersion, 1, 100)
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, tx.AddOutput(*addr, amount))
+	}
+	out := tx.Outputs[3]
+
+	// The receiver derives the same tag from the shared secret
+	secret := keyPair.SharedSecret(tx.R)
+	assert.Equal(t, out.ViewTag, key.ViewTag(secret, 3))
+
+	// The tag is only encoded from the view tag version on
+	for _, version := range []uint8{0, ViewTagVersion} {
+		buf := new(bytes.Buffer)
+		assert.NoError(t, marshalOutput(buf, out, version))
+
+		expectedLen := 4 * 32
+		if version >= ViewTagVersion {
+			expectedLen++
+		}
+		assert.Equal(t, expectedLen, buf.Len())
+
+		decoded := &Output{}
+		assert.NoError(t, unmarshalOutput(buf, decoded, version))
+		assert.True(t, out.Equals(decoded))
+		if version >= ViewTagVersion {
+			assert.Equal(t, out.ViewTag, decoded.ViewTag)
+		} else {
+			assert.Equal(t, uint8(0), decoded.ViewTag)
+		}
+	}
+}
@@ -18,8 +18,12 @@ import (
 )
 
 const minDecoys = 7
-const maxInputs = 2000
-const maxOutputs = 16
+
+// MaxInputs is the maximum amount of inputs a transaction can hold
+const MaxInputs = 2000
+
+// MaxOutputs is the maximum amount of outputs a transaction can hold
+const MaxOutputs = 16
 
 type FetchDecoys func(numMixins int) []mlsag.PubKeys
 
@@ -120,7 +124,7 @@ func (s *Standard) setTxFee(fee int64) error {
 }
 
 func (s *Standard) AddInput(i *Input) error {
-	if len(s.Inputs)+1 > maxInputs {
+	if len(s.Inputs)+1 > MaxInputs {
 		return errors.New("maximum amount of inputs reached")
 	}
 	s.Inputs = append(s.Inputs, i)
@@ -128,7 +132,7 @@ func (s *Standard) AddInput(i *Input) error {
 }
 
 func (s *Standard) AddOutput(pubAddr key.PublicAddress, amount ristretto.Scalar) error {
-	if len(s.Outputs)+1 > maxOutputs {
+	if len(s.Outputs)+1 > MaxOutputs {
 		return errors.New("maximum amount of outputs reached")
 	}
 
@@ -138,6 +142,12 @@ func (s *Standard) AddOutput(pubAddr key.PublicAddress, amount ristretto.Scalar)
 	}
 
 	output := NewOutput(s.r, amount, s.index, *pubKey)
+	output.address = pubAddr
+
+	// The tag costs a scalar multiplication, which older versions can skip
+	if s.Version >= ViewTagVersion {
+		output.ViewTag = pubKey.ViewTag(s.r, s.index)
+	}
 
 	s.Outputs = append(s.Outputs, output)
 
@@ -410,7 +420,7 @@ func marshalStandard(b *bytes.Buffer, tx *Standard) error {
 	}
 
 	for _, output := range tx.Outputs {
-		if err := marshalOutput(b, output); err != nil {
+		if err := marshalOutput(b, output, tx.Version); err != nil {
 			return err
 		}
 	}
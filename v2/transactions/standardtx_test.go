@@ -21,7 +21,7 @@ func TestAddOutputs(t *testing.T) {
 	var amountToSend ristretto.Scalar
 	amountToSend.SetBigInt(big.NewInt(20))
 
-	for i := 0; i < maxOutputs; i++ {
+	for i := 0; i < MaxOutputs; i++ {
 		err = tx.AddOutput(*pubAddr, amountToSend)
 		assert.Nil(t, err)
 	}
@@ -30,14 +30,14 @@ func TestAddOutputs(t *testing.T) {
 	err = tx.AddOutput(*pubAddr, amountToSend)
 	assert.NotNil(t, err)
 
-	// TotalSent = maxOutputs * amountToSend
-	assert.Equal(t, tx.TotalSent.BigInt().Int64(), maxOutputs*amountToSend.BigInt().Int64())
+	// TotalSent = MaxOutputs * amountToSend
+	assert.Equal(t, tx.TotalSent.BigInt().Int64(), MaxOutputs*amountToSend.BigInt().Int64())
 }
 
 func TestAddMaxInputs(t *testing.T) {
 	tx, _, _ := randomStandard(t)
 
-	for i := 0; i < maxInputs; i++ {
+	for i := 0; i < MaxInputs; i++ {
 		err := tx.AddInput(&Input{})
 		assert.Nil(t, err)
 	}
@@ -148,7 +148,7 @@ func TestProveRangeProof(t *testing.T) {
 	var amountToSend ristretto.Scalar
 	amountToSend.SetBigInt(big.NewInt(rand.Int63()))
 
-	for i := 0; i < maxOutputs; i++ {
+	for i := 0; i < MaxOutputs; i++ {
 		err = tx.AddOutput(*pubAddr, amountToSend)
 		assert.Nil(t, err)
 	}
@@ -157,7 +157,7 @@ func TestProveRangeProof(t *testing.T) {
 	assert.Nil(t, err)
 
 	// Check that each output now has the correct commitment
-	for i := 0; i < maxOutputs; i++ {
+	for i := 0; i < MaxOutputs; i++ {
 		output := tx.Outputs[i]
 		expected := CommitAmount(output.amount, output.mask)
 		assert.True(t, output.Commitment.Equals(&expected))
@@ -28,3 +28,22 @@ var _ Transaction = (*Bid)(nil)
 var _ Transaction = (*Stake)(nil)
 var _ Transaction = (*Standard)(nil)
 var _ Transaction = (*Timelock)(nil)
+
+func (t TxType) String() string {
+	switch t {
+	case CoinbaseType:
+		return "coinbase"
+	case BidType:
+		return "bid"
+	case StakeType:
+		return "stake"
+	case StandardType:
+		return "standard"
+	case TimelockType:
+		return "timelock"
+	case ContractType:
+		return "contract"
+	default:
+		return "unknown"
+	}
+}
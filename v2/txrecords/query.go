@@ -0,0 +1,191 @@
+package txrecords
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sort"
+
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+)
+
+// SortKey is the field a query orders its results by.
+type SortKey uint8
+
+const (
+	ByHeight SortKey = iota
+	ByTime
+)
+
+// Query selects and orders tx records. Zero values mean no filtering.
+type Query struct {
+	// Directions and TxTypes restrict the results to records matching any of
+	// the given values
+	Directions []Direction
+	TxTypes    []transactions.TxType
+
+	// MinHeight and MaxHeight bound the height, inclusive. A MaxHeight of 0
+	// sets no upper bound
+	MinHeight, MaxHeight uint64
+	// FromTime and ToTime bound the timestamp, inclusive. A ToTime of 0
+	// sets no upper bound
+	FromTime, ToTime int64
+	// MinAmount and MaxAmount bound the amount, inclusive. A MaxAmount of 0
+	// sets no upper bound
+	MinAmount, MaxAmount uint64
+
+	// Counterparty only matches outgoing records paying to the given public
+	// address. Incoming records have no counterparty, so they never match
+	Counterparty string
+
+	SortBy     SortKey
+	Descending bool
+
+	// Limit is the maximum amount of records returned. 0 returns them all
+	Limit int
+	// Cursor continues a previous query, and should be set to the cursor it
+	// returned
+	Cursor string
+}
+
+// ErrInvalidCursor is returned when a query cursor can not be decoded.
+var ErrInvalidCursor = errors.New("invalid query cursor")
+
+// Apply filters and sorts `records` according to the query, and returns the
+// requested page. If more records follow, a cursor for the next page is
+// returned along with it, otherwise the cursor is empty.
+func (q Query) Apply(records []TxRecord) ([]TxRecord, string, error) {
+	after, err := q.After()
+	if err != nil {
+		return nil, "", err
+	}
+
+	results := make([]TxRecord, 0)
+	for _, record := range records {
+		if !q.Matches(record) {
+			continue
+		}
+
+		if after != nil && !q.less(after, q.Position(record)) {
+			continue
+		}
+
+		results = append(results, record)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return q.less(q.Position(results[i]), q.Position(results[j]))
+	})
+
+	if q.Limit <= 0 || len(results) <= q.Limit {
+		return results, "", nil
+	}
+
+	results = results[:q.Limit]
+	return results, q.NextCursor(results[q.Limit-1]), nil
+}
+
+// After returns the position the cursor of the query continues after, or
+// nil if the query has no cursor.
+func (q Query) After() ([]byte, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	after, err := hex.DecodeString(q.Cursor)
+	if err != nil || len(after) < 8 {
+		return nil, ErrInvalidCursor
+	}
+
+	return after, nil
+}
+
+// NextCursor returns the cursor of the page following `last`, the last
+// record of a page.
+func (q Query) NextCursor(last TxRecord) string {
+	return hex.EncodeToString(q.Position(last))
+}
+
+// Matches reports whether `r` passes the filters of the query.
+func (q Query) Matches(r TxRecord) bool {
+	if len(q.Directions) > 0 && !containsDirection(q.Directions, r.Direction) {
+		return false
+	}
+
+	if len(q.TxTypes) > 0 && !containsTxType(q.TxTypes, r.TxType) {
+		return false
+	}
+
+	if r.Height < q.MinHeight || (q.MaxHeight != 0 && r.Height > q.MaxHeight) {
+		return false
+	}
+
+	if r.Timestamp < q.FromTime || (q.ToTime != 0 && r.Timestamp > q.ToTime) {
+		return false
+	}
+
+	if r.Amount < q.MinAmount || (q.MaxAmount != 0 && r.Amount > q.MaxAmount) {
+		return false
+	}
+
+	if q.Counterparty != "" && !containsString(r.Counterparties, q.Counterparty) {
+		return false
+	}
+
+	return true
+}
+
+// Position returns the place of a record in the sort order, as the sort
+// key followed by the txid. The txid breaks ties between records in the
+// same block, so that cursors are stable. Positions are big endian, so they
+// sort like the records.
+func (q Query) Position(r TxRecord) []byte {
+	pos := make([]byte, 8, 8+len(r.TxID))
+	if q.SortBy == ByTime {
+		// Flip the sign bit, so that negative timestamps sort first
+		binary.BigEndian.PutUint64(pos, uint64(r.Timestamp)^(1<<63))
+	} else {
+		binary.BigEndian.PutUint64(pos, r.Height)
+	}
+
+	return append(pos, r.TxID...)
+}
+
+func (q Query) less(a, b []byte) bool {
+	if q.Descending {
+		return bytes.Compare(a, b) > 0
+	}
+
+	return bytes.Compare(a, b) < 0
+}
+
+func containsDirection(directions []Direction, d Direction) bool {
+	for _, direction := range directions {
+		if direction == d {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsTxType(txTypes []transactions.TxType, t transactions.TxType) bool {
+	for _, txType := range txTypes {
+		if txType == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+
+	return false
+}
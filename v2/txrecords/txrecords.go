@@ -4,10 +4,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
-	"io/ioutil"
-	"time"
+	"fmt"
+	"io"
 
-	"github.com/dusk-network/dusk-wallet/v2/key"
+	"github.com/dusk-network/dusk-wallet/v2/block"
 	"github.com/dusk-network/dusk-wallet/v2/transactions"
 )
 
@@ -20,39 +20,147 @@ const (
 	Out
 )
 
+func (d Direction) String() string {
+	if d == Out {
+		return "out"
+	}
+
+	return "in"
+}
+
+// State tells whether a transaction was included in a block yet.
+type State uint8
+
+const (
+	Pending State = iota
+	Confirmed
+)
+
+func (s State) String() string {
+	if s == Confirmed {
+		return "confirmed"
+	}
+
+	return "pending"
+}
+
+// version is written in front of every encoded record.
+const version uint8 = 1
+
+// TxRecord describes the effect a transaction had on the wallet.
 type TxRecord struct {
+	TxID []byte
 	Direction
+	State
 	Timestamp int64
 	Height    uint64
+	BlockHash []byte
 	transactions.TxType
-	Amount       uint64
+	// Amount is the net change of the wallet balance. For outgoing txs, this
+	// includes the fee.
+	Amount uint64
+	// Fee is the fee paid by the wallet, so it is only set for outgoing txs
+	Fee          uint64
 	UnlockHeight uint64
-	Recipient    string
+	// PaymentID is an optional identifier attached to the record by the user
+	PaymentID []byte
+	// Label is an optional description attached to the record by the user
+	Label string
+	// Recipients holds the one-time pubkeys of all outputs of an outgoing tx
+	// which do not belong to the wallet.
+	Recipients []string
+	// Counterparties holds the public addresses an outgoing tx pays to. They
+	// are only known for txs built by the wallet. Incoming records have no
+	// counterparty, as a tx does not tell who sent it.
+	Counterparties []string
+	// Proof proves the tx is included in the block with hash BlockHash. It
+	// is only set for confirmed txs.
+	Proof *block.InclusionProof
 }
 
-func New(tx transactions.Transaction, height uint64, direction Direction, privView *key.PrivateView) *TxRecord {
+// New creates a record for `tx`, confirmed in the block with hash
+// `blockHash` at `height` and with `timestamp`. `owned` holds the amounts of
+// the outputs belonging to the wallet, by output index, and `spent` is the
+// total amount of the wallet's inputs spent by `tx`.
+func New(tx transactions.Transaction, height uint64, timestamp int64, blockHash []byte, owned map[uint32]uint64, spent uint64) (*TxRecord, error) {
+	txID, err := tx.CalculateHash()
+	if err != nil {
+		return nil, err
+	}
+
+	var received uint64
+	for _, amount := range owned {
+		received += amount
+	}
+
+	// The coinbase maturity depends on the network, and is added by the
+	// wallet
+	_, lockTime := transactions.OutputLock(tx, 0, transactions.PaymentOutput, 0)
 	t := &TxRecord{
-		Direction:    direction,
-		Timestamp:    time.Now().Unix(),
-		Height:       height,
-		TxType:       tx.Type(),
-		Amount:       tx.StandardTx().Outputs[0].EncryptedAmount.BigInt().Uint64(),
-		UnlockHeight: height + tx.LockTime(),
-		Recipient:    hex.EncodeToString(tx.StandardTx().Outputs[0].PubKey.P.Bytes()),
+		TxID:           txID,
+		Direction:      In,
+		State:          Confirmed,
+		Timestamp:      timestamp,
+		Height:         height,
+		BlockHash:      blockHash,
+		TxType:         tx.Type(),
+		UnlockHeight:   height + lockTime,
+		Recipients:     make([]string, 0),
+		Counterparties: make([]string, 0),
 	}
 
-	if transactions.ShouldEncryptValues(tx) {
-		amountScalar := transactions.DecryptAmount(tx.StandardTx().Outputs[0].EncryptedAmount, tx.StandardTx().R, 0, *privView)
-		t.Amount = amountScalar.BigInt().Uint64()
+	if spent <= received {
+		t.Amount = received - spent
+		return t, nil
 	}
-	return t
+
+	// The fee is paid by the sender, so incoming records have none
+	t.Direction = Out
+	t.Amount = spent - received
+	t.Fee = tx.StandardTx().Fee.BigInt().Uint64()
+
+	for i, output := range tx.StandardTx().Outputs {
+		if _, ok := owned[uint32(i)]; ok {
+			continue
+		}
+
+		t.Recipients = append(t.Recipients, hex.EncodeToString(output.PubKey.P.Bytes()))
+		addr := output.Address().String()
+		if addr != "" && !containsString(t.Counterparties, addr) {
+			t.Counterparties = append(t.Counterparties, addr)
+		}
+	}
+
+	return t, nil
+}
+
+// Confirmations returns the number of blocks burying the tx, once the
+// wallet processed all blocks below `walletHeight`. Pending txs have none.
+func (t *TxRecord) Confirmations(walletHeight uint64) uint64 {
+	if t.State == Pending || walletHeight <= t.Height {
+		return 0
+	}
+
+	return walletHeight - t.Height
 }
 
 func Encode(b *bytes.Buffer, t *TxRecord) error {
+	if err := binary.Write(b, binary.LittleEndian, version); err != nil {
+		return err
+	}
+
+	if err := writeBytes(b, t.TxID); err != nil {
+		return err
+	}
+
 	if err := binary.Write(b, binary.LittleEndian, t.Direction); err != nil {
 		return err
 	}
 
+	if err := binary.Write(b, binary.LittleEndian, t.State); err != nil {
+		return err
+	}
+
 	if err := binary.Write(b, binary.LittleEndian, t.Timestamp); err != nil {
 		return err
 	}
@@ -61,6 +169,10 @@ func Encode(b *bytes.Buffer, t *TxRecord) error {
 		return err
 	}
 
+	if err := writeBytes(b, t.BlockHash); err != nil {
+		return err
+	}
+
 	if err := binary.Write(b, binary.LittleEndian, t.TxType); err != nil {
 		return err
 	}
@@ -69,19 +181,68 @@ func Encode(b *bytes.Buffer, t *TxRecord) error {
 		return err
 	}
 
+	if err := binary.Write(b, binary.LittleEndian, t.Fee); err != nil {
+		return err
+	}
+
 	if err := binary.Write(b, binary.LittleEndian, t.UnlockHeight); err != nil {
 		return err
 	}
 
-	_, err := b.Write([]byte(t.Recipient))
-	return err
+	if err := writeBytes(b, t.PaymentID); err != nil {
+		return err
+	}
+
+	if err := writeString(b, t.Label); err != nil {
+		return err
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, uint32(len(t.Recipients))); err != nil {
+		return err
+	}
+
+	for _, recipient := range t.Recipients {
+		if err := writeString(b, recipient); err != nil {
+			return err
+		}
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, uint32(len(t.Counterparties))); err != nil {
+		return err
+	}
+
+	for _, counterparty := range t.Counterparties {
+		if err := writeString(b, counterparty); err != nil {
+			return err
+		}
+	}
+
+	return encodeProof(b, t.Proof)
 }
 
 func Decode(b *bytes.Buffer, t *TxRecord) error {
+	var v uint8
+	if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+		return err
+	}
+
+	if v != version {
+		return fmt.Errorf("unknown tx record version %d", v)
+	}
+
+	var err error
+	if t.TxID, err = readBytes(b); err != nil {
+		return err
+	}
+
 	if err := binary.Read(b, binary.LittleEndian, &t.Direction); err != nil {
 		return err
 	}
 
+	if err := binary.Read(b, binary.LittleEndian, &t.State); err != nil {
+		return err
+	}
+
 	if err := binary.Read(b, binary.LittleEndian, &t.Timestamp); err != nil {
 		return err
 	}
@@ -90,6 +251,10 @@ func Decode(b *bytes.Buffer, t *TxRecord) error {
 		return err
 	}
 
+	if t.BlockHash, err = readBytes(b); err != nil {
+		return err
+	}
+
 	if err := binary.Read(b, binary.LittleEndian, &t.TxType); err != nil {
 		return err
 	}
@@ -98,15 +263,146 @@ func Decode(b *bytes.Buffer, t *TxRecord) error {
 		return err
 	}
 
+	if err := binary.Read(b, binary.LittleEndian, &t.Fee); err != nil {
+		return err
+	}
+
 	if err := binary.Read(b, binary.LittleEndian, &t.UnlockHeight); err != nil {
 		return err
 	}
 
-	recipientBytes, err := ioutil.ReadAll(b)
-	if err != nil {
+	if t.PaymentID, err = readBytes(b); err != nil {
+		return err
+	}
+
+	if t.Label, err = readString(b); err != nil {
+		return err
+	}
+
+	var lenRecipients uint32
+	if err := binary.Read(b, binary.LittleEndian, &lenRecipients); err != nil {
+		return err
+	}
+
+	t.Recipients = make([]string, lenRecipients)
+	for i := range t.Recipients {
+		recipient, err := readString(b)
+		if err != nil {
+			return err
+		}
+		t.Recipients[i] = recipient
+	}
+
+	var lenCounterparties uint32
+	if err := binary.Read(b, binary.LittleEndian, &lenCounterparties); err != nil {
 		return err
 	}
 
-	t.Recipient = string(recipientBytes)
+	t.Counterparties = make([]string, lenCounterparties)
+	for i := range t.Counterparties {
+		counterparty, err := readString(b)
+		if err != nil {
+			return err
+		}
+		t.Counterparties[i] = counterparty
+	}
+
+	t.Proof, err = decodeProof(b)
+	return err
+}
+
+// encodeProof writes the number of siblings of `p`, followed by its index
+// and siblings. Records without a proof have no siblings.
+func encodeProof(b *bytes.Buffer, p *block.InclusionProof) error {
+	if p == nil {
+		return binary.Write(b, binary.LittleEndian, uint8(0))
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, uint8(len(p.Siblings))); err != nil {
+		return err
+	}
+
+	if err := binary.Write(b, binary.LittleEndian, p.Index); err != nil {
+		return err
+	}
+
+	for _, sibling := range p.Siblings {
+		if err := writeBytes(b, sibling); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+func decodeProof(b *bytes.Buffer) (*block.InclusionProof, error) {
+	var lenSiblings uint8
+	if err := binary.Read(b, binary.LittleEndian, &lenSiblings); err != nil {
+		return nil, err
+	}
+
+	if lenSiblings == 0 {
+		return nil, nil
+	}
+
+	p := &block.InclusionProof{Siblings: make([][]byte, lenSiblings)}
+	if err := binary.Read(b, binary.LittleEndian, &p.Index); err != nil {
+		return nil, err
+	}
+
+	for i := range p.Siblings {
+		sibling, err := readBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		p.Siblings[i] = sibling
+	}
+
+	return p, nil
+}
+
+func writeBytes(b *bytes.Buffer, bs []byte) error {
+	if err := binary.Write(b, binary.LittleEndian, uint8(len(bs))); err != nil {
+		return err
+	}
+
+	_, err := b.Write(bs)
+	return err
+}
+
+func readBytes(b *bytes.Buffer) ([]byte, error) {
+	var l uint8
+	if err := binary.Read(b, binary.LittleEndian, &l); err != nil {
+		return nil, err
+	}
+
+	bs := make([]byte, l)
+	if _, err := io.ReadFull(b, bs); err != nil {
+		return nil, err
+	}
+
+	return bs, nil
+}
+
+func writeString(b *bytes.Buffer, s string) error {
+	if err := binary.Write(b, binary.LittleEndian, uint16(len(s))); err != nil {
+		return err
+	}
+
+	_, err := b.WriteString(s)
+	return err
+}
+
+func readString(b *bytes.Buffer) (string, error) {
+	var l uint16
+	if err := binary.Read(b, binary.LittleEndian, &l); err != nil {
+		return "", err
+	}
+
+	bs := make([]byte, l)
+	if _, err := io.ReadFull(b, bs); err != nil {
+		return "", err
+	}
+
+	return string(bs), nil
+}
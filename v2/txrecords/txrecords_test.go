@@ -2,9 +2,15 @@ package txrecords_test
 
 import (
 	"bytes"
+	"encoding/hex"
+	"math/big"
+	"math/rand"
 	"testing"
 	"time"
 
+	ristretto "github.com/bwesterb/go-ristretto"
+	"github.com/dusk-network/dusk-wallet/v2/block"
+	"github.com/dusk-network/dusk-wallet/v2/key"
 	"github.com/dusk-network/dusk-wallet/v2/transactions"
 	"github.com/dusk-network/dusk-wallet/v2/txrecords"
 	"gotest.tools/assert"
@@ -13,13 +19,21 @@ import (
 // Ensure integrity of data between encoding and decoding
 func TestEncodeDecodeTxRecord(t *testing.T) {
 	r := &txrecords.TxRecord{
-		Direction:    txrecords.In,
-		Timestamp:    time.Now().Unix(),
-		Height:       500,
-		TxType:       transactions.BidType,
-		Amount:       7172727182793,
-		UnlockHeight: 300000,
-		Recipient:    "pippo",
+		TxID:           []byte{1, 2, 3, 4},
+		Direction:      txrecords.In,
+		State:          txrecords.Confirmed,
+		BlockHash:      []byte{5, 6, 7, 8},
+		PaymentID:      []byte("invoice 42"),
+		Label:          "rent",
+		Timestamp:      time.Now().Unix(),
+		Height:         500,
+		TxType:         transactions.BidType,
+		Amount:         7172727182793,
+		Fee:            100,
+		UnlockHeight:   300000,
+		Recipients:     []string{"pippo", "pluto"},
+		Counterparties: []string{"paperino"},
+		Proof:          &block.InclusionProof{Index: 3, Siblings: [][]byte{{9}, {10, 11}}},
 	}
 
 	buf := new(bytes.Buffer)
@@ -32,11 +46,177 @@ func TestEncodeDecodeTxRecord(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	assert.DeepEqual(t, r.TxID, decoded.TxID)
 	assert.Equal(t, r.Direction, decoded.Direction)
+	assert.Equal(t, r.State, decoded.State)
+	assert.DeepEqual(t, r.BlockHash, decoded.BlockHash)
+	assert.DeepEqual(t, r.PaymentID, decoded.PaymentID)
+	assert.Equal(t, r.Label, decoded.Label)
 	assert.Equal(t, r.Timestamp, decoded.Timestamp)
 	assert.Equal(t, r.Height, decoded.Height)
 	assert.Equal(t, r.TxType, decoded.TxType)
 	assert.Equal(t, r.Amount, decoded.Amount)
+	assert.Equal(t, r.Fee, decoded.Fee)
 	assert.Equal(t, r.UnlockHeight, decoded.UnlockHeight)
-	assert.Equal(t, r.Recipient, decoded.Recipient)
+	assert.DeepEqual(t, r.Recipients, decoded.Recipients)
+	assert.DeepEqual(t, r.Counterparties, decoded.Counterparties)
+	assert.DeepEqual(t, r.Proof, decoded.Proof)
+
+	// Records without a proof decode without one
+	r.Proof = nil
+	if err := txrecords.Encode(buf, r); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded = &txrecords.TxRecord{}
+	if err := txrecords.Decode(buf, decoded); err != nil {
+		t.Fatal(err)
+	}
+	assert.Assert(t, decoded.Proof == nil)
+}
+
+// Ensure records of an unknown version are rejected
+func TestDecodeUnknownVersion(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := txrecords.Encode(buf, &txrecords.TxRecord{}); err != nil {
+		t.Fatal(err)
+	}
+
+	bs := buf.Bytes()
+	bs[0] = 0xff
+	assert.ErrorContains(t, txrecords.Decode(bytes.NewBuffer(bs), &txrecords.TxRecord{}), "version")
+}
+
+// Ensure the record holds the net effect of a tx on the wallet
+func TestNewTxRecord(t *testing.T) {
+	tx, err := transactions.NewTimelock(0, 1, 100, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := make([]string, 3)
+	for i := range addrs {
+		var amount ristretto.Scalar
+		amount.SetBigInt(big.NewInt(500))
+		seed := make([]byte, 64)
+		rand.Read(seed)
+		addr, err := key.NewKeyPair(seed).PublicKey().PublicAddress(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs[i] = addr.String()
+
+		if err := tx.AddOutput(*addr, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// We received output 2, worth 500
+	r, err := txrecords.New(tx, 10, 1234, []byte{1}, map[uint32]uint64{2: 500}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txID, err := tx.CalculateHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.DeepEqual(t, txID, r.TxID)
+	assert.Equal(t, txrecords.Confirmed, r.State)
+	assert.Equal(t, txrecords.In, r.Direction)
+	assert.Equal(t, uint64(500), r.Amount)
+	assert.Equal(t, uint64(0), r.Fee)
+	assert.Equal(t, int64(1234), r.Timestamp)
+	assert.Equal(t, uint64(1010), r.UnlockHeight)
+	assert.Equal(t, 0, len(r.Recipients))
+	assert.Equal(t, 0, len(r.Counterparties))
+
+	// We sent outputs 0 and 1, spending 1600 and receiving 500 in change
+	r, err = txrecords.New(tx, 10, 1234, []byte{1}, map[uint32]uint64{2: 500}, 1600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, txrecords.Out, r.Direction)
+	assert.Equal(t, uint64(1100), r.Amount)
+	assert.Equal(t, uint64(100), r.Fee)
+	assert.DeepEqual(t, []string{
+		hex.EncodeToString(tx.Outputs[0].PubKey.P.Bytes()),
+		hex.EncodeToString(tx.Outputs[1].PubKey.P.Bytes()),
+	}, r.Recipients)
+	assert.DeepEqual(t, addrs[:2], r.Counterparties)
+}
+
+func TestConfirmations(t *testing.T) {
+	r := &txrecords.TxRecord{State: txrecords.Confirmed, Height: 10}
+	assert.Equal(t, uint64(0), r.Confirmations(10))
+	assert.Equal(t, uint64(1), r.Confirmations(11))
+	assert.Equal(t, uint64(5), r.Confirmations(15))
+
+	r.State = txrecords.Pending
+	assert.Equal(t, uint64(0), r.Confirmations(15))
+}
+
+func TestQuery(t *testing.T) {
+	records := make([]txrecords.TxRecord, 0, 50)
+	for i := 0; i < 50; i++ {
+		r := txrecords.TxRecord{
+			TxID:      []byte{byte(i)},
+			Direction: txrecords.Direction(i % 2),
+			Height:    uint64(i / 5),
+			Timestamp: int64(1000 - i),
+			TxType:    transactions.TxType(i % 5),
+			Amount:    uint64(i * 100),
+		}
+
+		if r.Direction == txrecords.Out {
+			r.Counterparties = []string{hex.EncodeToString([]byte{byte(i % 3)})}
+		}
+
+		records = append(records, r)
+	}
+
+	// Filter
+	q := txrecords.Query{
+		Directions:   []txrecords.Direction{txrecords.Out},
+		MinHeight:    2,
+		MaxHeight:    7,
+		MinAmount:    1500,
+		Counterparty: "00",
+	}
+
+	results, cursor, err := q.Apply(records)
+	assert.NilError(t, err)
+	assert.Equal(t, "", cursor)
+	for _, r := range results {
+		assert.Equal(t, txrecords.Out, r.Direction)
+		assert.Assert(t, r.Height >= 2 && r.Height <= 7)
+		assert.Assert(t, r.Amount >= 1500)
+		assert.Equal(t, 0, int(r.TxID[0])%3)
+	}
+	// Odd records from 15 to 39 which are a multiple of 3
+	assert.Equal(t, 5, len(results))
+
+	// Page through everything, newest first
+	q = txrecords.Query{SortBy: txrecords.ByTime, Descending: true, Limit: 7}
+	var all []txrecords.TxRecord
+	for {
+		page, cursor, err := q.Apply(records)
+		assert.NilError(t, err)
+		all = append(all, page...)
+		if cursor == "" {
+			break
+		}
+		q.Cursor = cursor
+	}
+
+	assert.Equal(t, 50, len(all))
+	for i := 1; i < len(all); i++ {
+		assert.Assert(t, all[i-1].Timestamp > all[i].Timestamp)
+	}
+
+	q.Cursor = "zz"
+	_, _, err = q.Apply(records)
+	assert.Equal(t, txrecords.ErrInvalidCursor, err)
 }
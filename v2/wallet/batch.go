@@ -0,0 +1,124 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	ristretto "github.com/bwesterb/go-ristretto"
+	"github.com/dusk-network/dusk-wallet/v2/key"
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+)
+
+// paymentsPerTx is the amount of payments which fit in a single transaction.
+// One output is always reserved for the change.
+const paymentsPerTx = transactions.MaxOutputs - 1
+
+// Payment is a single amount of DUSK, in atomic units, to be sent to an address.
+type Payment struct {
+	Address key.PublicAddress
+	Amount  uint64
+}
+
+// PaymentReceipt tells which transaction, and which output of that
+// transaction, paid out a Payment.
+type PaymentReceipt struct {
+	Payment
+	TxID  []byte
+	Index uint32
+}
+
+// ReadPayments reads a list of payments from CSV formatted data, where each
+// record holds an address and an amount in atomic units. A header line is
+// skipped, if present.
+func ReadPayments(r io.Reader) ([]Payment, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	payments := make([]Payment, 0, len(records))
+	for i, record := range records {
+		amount, err := strconv.ParseUint(strings.TrimSpace(record[1]), 10, 64)
+		if err != nil {
+			if i == 0 {
+				// Header line
+				continue
+			}
+			return nil, fmt.Errorf("invalid amount on line %d: %v", i+1, err)
+		}
+
+		payments = append(payments, Payment{
+			Address: key.PublicAddress(strings.TrimSpace(record[0])),
+			Amount:  amount,
+		})
+	}
+
+	return payments, nil
+}
+
+// NewBatchPayment pays out all given payments, using as few Standard
+// transactions as possible. Every transaction pays the given fee, and is
+// signed before the next one is created, so that no input is spent twice.
+// The returned receipts are in the same order as the payments.
+//
+// If an error occurs part-way, the transactions signed up to that point are
+// returned along with the error, as their inputs are no longer available.
+func (w *Wallet) NewBatchPayment(fee int64, payments []Payment) ([]*transactions.Standard, []PaymentReceipt, error) {
+	if len(payments) == 0 {
+		return nil, nil, errors.New("no payments provided")
+	}
+
+	numTxs := (len(payments) + paymentsPerTx - 1) / paymentsPerTx
+	txs := make([]*transactions.Standard, 0, numTxs)
+	receipts := make([]PaymentReceipt, 0, len(payments))
+
+	for start := 0; start < len(payments); start += paymentsPerTx {
+		end := start + paymentsPerTx
+		if end > len(payments) {
+			end = len(payments)
+		}
+
+		tx, err := w.NewStandardTx(fee)
+		if err != nil {
+			return txs, receipts, err
+		}
+
+		for _, payment := range payments[start:end] {
+			var amount ristretto.Scalar
+			amount.SetBigInt(new(big.Int).SetUint64(payment.Amount))
+
+			if err := tx.AddOutput(payment.Address, amount); err != nil {
+				return txs, receipts, err
+			}
+		}
+
+		if err := w.Sign(tx); err != nil {
+			return txs, receipts, err
+		}
+
+		txid, err := tx.CalculateHash()
+		if err != nil {
+			return txs, receipts, err
+		}
+
+		txs = append(txs, tx)
+		for i, payment := range payments[start:end] {
+			receipts = append(receipts, PaymentReceipt{
+				Payment: payment,
+				TxID:    txid,
+				Index:   tx.Outputs[i].Index,
+			})
+		}
+	}
+
+	return txs, receipts, nil
+}
@@ -0,0 +1,286 @@
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/dusk-network/dusk-wallet/v2/block"
+)
+
+// ErrBlockNotFound is returned by a BlockSource which does not have the
+// requested block. The Syncer does not retry requests failing with it.
+var ErrBlockNotFound = errors.New("block not found")
+
+// TransientError wraps an error of a BlockSource request which may go away
+// when the request is repeated, like a dropped connection. The Syncer only
+// retries requests failing with an error whose Temporary method returns
+// true, like TransientError and the timeouts of the net package. Anything
+// else, such as a block which can not be decoded, fails the sync right away.
+type TransientError struct {
+	Err error
+}
+
+func (e TransientError) Error() string {
+	return e.Err.Error()
+}
+
+// Temporary reports the error as transient.
+func (e TransientError) Temporary() bool {
+	return true
+}
+
+// isTransient returns true if `err` reports itself as temporary.
+func isTransient(err error) bool {
+	t, ok := err.(interface{ Temporary() bool })
+	return ok && t.Temporary()
+}
+
+// BlockSource provides the blocks the wallet is synced with.
+type BlockSource interface {
+	// TipHeight returns the height of the latest block
+	TipHeight(ctx context.Context) (uint64, error)
+	// BlockAtHeight returns the block at `height`
+	BlockAtHeight(ctx context.Context, height uint64) (*block.Block, error)
+	// BlockByHash returns the block with the given header hash
+	BlockByHash(ctx context.Context, hash []byte) (*block.Block, error)
+}
+
+// MemorySource is a BlockSource which keeps its blocks in memory.
+type MemorySource struct {
+	lock     sync.RWMutex
+	byHeight map[uint64]*block.Block
+	byHash   map[string]*block.Block
+	tip      uint64
+}
+
+// NewMemorySource returns a MemorySource holding `blks`.
+func NewMemorySource(blks ...*block.Block) *MemorySource {
+	m := &MemorySource{
+		byHeight: make(map[uint64]*block.Block),
+		byHash:   make(map[string]*block.Block),
+	}
+
+	for _, blk := range blks {
+		m.Add(blk)
+	}
+
+	return m
+}
+
+// Add stores `blk`, replacing any block stored at the same height.
+func (m *MemorySource) Add(blk *block.Block) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if old, ok := m.byHeight[blk.Header.Height]; ok {
+		delete(m.byHash, hex.EncodeToString(old.Header.Hash))
+	}
+
+	m.byHeight[blk.Header.Height] = blk
+	m.byHash[hex.EncodeToString(blk.Header.Hash)] = blk
+	if blk.Header.Height > m.tip {
+		m.tip = blk.Header.Height
+	}
+}
+
+// TipHeight implements BlockSource.
+func (m *MemorySource) TipHeight(ctx context.Context) (uint64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if len(m.byHeight) == 0 {
+		return 0, ErrBlockNotFound
+	}
+
+	return m.tip, nil
+}
+
+// BlockAtHeight implements BlockSource.
+func (m *MemorySource) BlockAtHeight(ctx context.Context, height uint64) (*block.Block, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	blk, ok := m.byHeight[height]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+
+	return blk, nil
+}
+
+// BlockByHash implements BlockSource.
+func (m *MemorySource) BlockByHash(ctx context.Context, hash []byte) (*block.Block, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	blk, ok := m.byHash[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+
+	return blk, nil
+}
+
+// DecodeBlock decodes a block from its serialized form.
+type DecodeBlock func(b []byte) (*block.Block, error)
+
+// DecodeWireBlock is a DecodeBlock for blocks in the block file format
+// written by block.Marshal.
+func DecodeWireBlock(b []byte) (*block.Block, error) {
+	blk := block.NewBlock()
+	if err := block.Unmarshal(bytes.NewBuffer(b), blk); err != nil {
+		return nil, err
+	}
+
+	return blk, nil
+}
+
+// FileSource is a BlockSource reading blocks from a file. The file holds a
+// sequence of serialized blocks, each prefixed with its length as a
+// little endian uint32.
+type FileSource struct {
+	lock   sync.Mutex
+	file   *os.File
+	decode DecodeBlock
+
+	// offsets of the blocks in the file
+	byHeight map[uint64]int64
+	byHash   map[string]int64
+	tip      uint64
+}
+
+// NewFileSource opens the block file at `path`, and indexes the blocks
+// in it. The blocks are decoded with `decode`, which is DecodeWireBlock for
+// block files written with block.Marshal.
+func NewFileSource(path string, decode DecodeBlock) (*FileSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileSource{
+		file:     file,
+		decode:   decode,
+		byHeight: make(map[uint64]int64),
+		byHash:   make(map[string]int64),
+	}
+
+	if err := f.index(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *FileSource) index() error {
+	r := bufio.NewReader(f.file)
+	var offset int64
+	for {
+		bs, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		blk, err := f.decode(bs)
+		if err != nil {
+			return err
+		}
+
+		f.byHeight[blk.Header.Height] = offset
+		f.byHash[hex.EncodeToString(blk.Header.Hash)] = offset
+		if blk.Header.Height > f.tip {
+			f.tip = blk.Header.Height
+		}
+
+		offset += 4 + int64(len(bs))
+	}
+}
+
+// Close closes the underlying file.
+func (f *FileSource) Close() error {
+	return f.file.Close()
+}
+
+// TipHeight implements BlockSource.
+func (f *FileSource) TipHeight(ctx context.Context) (uint64, error) {
+	if len(f.byHeight) == 0 {
+		return 0, ErrBlockNotFound
+	}
+
+	return f.tip, nil
+}
+
+// BlockAtHeight implements BlockSource.
+func (f *FileSource) BlockAtHeight(ctx context.Context, height uint64) (*block.Block, error) {
+	offset, ok := f.byHeight[height]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+
+	return f.readBlock(offset)
+}
+
+// BlockByHash implements BlockSource.
+func (f *FileSource) BlockByHash(ctx context.Context, hash []byte) (*block.Block, error) {
+	offset, ok := f.byHash[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+
+	return f.readBlock(offset)
+}
+
+func (f *FileSource) readBlock(offset int64) (*block.Block, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, err := f.file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	bs, err := readFrame(f.file)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.decode(bs)
+}
+
+// WriteBlockFrame appends a serialized block to `w`, in the format read by
+// FileSource.
+func WriteBlockFrame(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var l uint32
+	if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+		return nil, err
+	}
+
+	bs := make([]byte, l)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return bs, nil
+}
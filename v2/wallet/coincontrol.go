@@ -0,0 +1,117 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+
+	ristretto "github.com/bwesterb/go-ristretto"
+	"github.com/dusk-network/dusk-wallet/v2/database"
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+)
+
+// Outputs returns all unspent outputs owned by this wallet.
+func (w *Wallet) Outputs() ([]database.OwnedOutput, error) {
+	privSpend, err := w.keyPair.PrivateSpend()
+	if err != nil {
+		return nil, err
+	}
+
+	return w.db.FetchOwnedOutputs(privSpend.Bytes())
+}
+
+// Freeze marks the output with the given one-time pubkey as frozen. Frozen
+// outputs are not spent, unless they are thawed first.
+func (w *Wallet) Freeze(pubKey ristretto.Point) error {
+	return w.setFrozen(pubKey, true)
+}
+
+// Thaw makes a frozen output spendable again.
+func (w *Wallet) Thaw(pubKey ristretto.Point) error {
+	return w.setFrozen(pubKey, false)
+}
+
+func (w *Wallet) setFrozen(pubKey ristretto.Point, frozen bool) error {
+	privSpend, err := w.keyPair.PrivateSpend()
+	if err != nil {
+		return err
+	}
+
+	return w.db.SetFrozen(privSpend.Bytes(), pubKey.Bytes(), frozen)
+}
+
+// addSelectedInputs spends the given outputs in the transaction, and sends
+// whatever is left after paying the outputs and fee back to the wallet.
+func (w *Wallet) addSelectedInputs(tx *transactions.Standard, outputs []ristretto.Point) error {
+	privSpend, err := w.keyPair.PrivateSpend()
+	if err != nil {
+		return err
+	}
+
+	inputs, total, err := w.db.FetchSelectedInputs(privSpend.Bytes(), outputs)
+	if err != nil {
+		return err
+	}
+
+	totalAmount := tx.Fee.BigInt().Int64() + tx.TotalSent.BigInt().Int64()
+	if total < totalAmount {
+		return errors.New("selected outputs do not account for the total amount inputted")
+	}
+
+	for _, input := range inputs {
+		if err := tx.AddInput(input); err != nil {
+			return err
+		}
+	}
+
+	return w.addChange(tx, total-totalAmount)
+}
+
+// UnlockEntry is an amount of DUSK which becomes spendable at a given height.
+type UnlockEntry struct {
+	Height uint64
+	Amount uint64
+	Reason transactions.LockReason
+}
+
+// UnlockSchedule returns when the currently locked funds of the wallet
+// become spendable, ordered by height. Outputs unlocking at the same height
+// for the same reason are added together.
+func (w *Wallet) UnlockSchedule() ([]UnlockEntry, error) {
+	outputs, err := w.Outputs()
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := make([]UnlockEntry, 0)
+	for _, output := range outputs {
+		if output.UnlockHeight == 0 {
+			continue
+		}
+
+		merged := false
+		for i := range schedule {
+			if schedule[i].Height == output.UnlockHeight && schedule[i].Reason == output.LockReason {
+				schedule[i].Amount += output.Amount
+				merged = true
+				break
+			}
+		}
+
+		if !merged {
+			schedule = append(schedule, UnlockEntry{
+				Height: output.UnlockHeight,
+				Amount: output.Amount,
+				Reason: output.LockReason,
+			})
+		}
+	}
+
+	sort.Slice(schedule, func(i, j int) bool {
+		if schedule[i].Height == schedule[j].Height {
+			return schedule[i].Reason < schedule[j].Reason
+		}
+		return schedule[i].Height < schedule[j].Height
+	})
+
+	return schedule, nil
+}
@@ -0,0 +1,63 @@
+package wallet
+
+import (
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+)
+
+// ConfirmationPolicy sets how many confirmations a received output needs
+// before it can be spent. The block including the output gives it its first
+// confirmation, so values of 0 and 1 make outputs spendable straight away.
+type ConfirmationPolicy struct {
+	// Change applies to change sent back by the wallet's own txs
+	Change uint64
+	// Received applies to all other outputs
+	Received uint64
+}
+
+// SetConfirmationPolicy sets the confirmations required for outputs
+// received from now on. Outputs which are not spendable yet are locked
+// with transactions.LockConfirmations, and count as locked balance.
+func (w *Wallet) SetConfirmationPolicy(p ConfirmationPolicy) {
+	w.confirmationPolicy = p
+}
+
+// SetCoinbaseMaturity sets the amount of blocks coinbase rewards received
+// from now on stay locked, which is a parameter of the network. With the
+// default of 0, rewards can be spent straight away.
+func (w *Wallet) SetCoinbaseMaturity(blocks uint64) {
+	w.coinbaseMaturity = blocks
+}
+
+// Confirmations returns the number of confirmations of the tx with id
+// `txID`. Pending txs have none.
+func (w *Wallet) Confirmations(txID []byte) (uint64, error) {
+	txRecord, err := w.db.GetTxRecord(txID)
+	if err != nil {
+		return 0, err
+	}
+
+	height, err := w.GetSavedHeight()
+	if err != nil {
+		return 0, err
+	}
+
+	return txRecord.Confirmations(height), nil
+}
+
+// confirmingBalance returns the amount of the outputs which only wait for
+// more confirmations to become spendable.
+func (w *Wallet) confirmingBalance() (uint64, error) {
+	outputs, err := w.Outputs()
+	if err != nil {
+		return 0, err
+	}
+
+	var confirming uint64
+	for _, output := range outputs {
+		if output.LockReason == transactions.LockConfirmations {
+			confirming += output.Amount
+		}
+	}
+
+	return confirming, nil
+}
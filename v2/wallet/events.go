@@ -0,0 +1,153 @@
+package wallet
+
+import (
+	"sync"
+
+	"github.com/bwesterb/go-ristretto"
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+)
+
+// EventType tells what happened to the wallet.
+type EventType uint8
+
+const (
+	// EventReceived is published for every output received by the wallet
+	EventReceived EventType = iota
+	// EventSpent is published for every owned output spent in a block
+	EventSpent
+	// EventUnlocked is published when a locked output becomes spendable
+	EventUnlocked
+	// EventConfirmed is published when a pending tx of the wallet is
+	// included in a block
+	EventConfirmed
+	// EventRollback is published when all chain-derived state from a height
+	// on is discarded
+	EventRollback
+	// EventSyncProgress is published by the Syncer after every block
+	EventSyncProgress
+	// EventDropped is published when a pending tx is discarded, because a
+	// block spent one of its inputs in another tx, or because it was
+	// abandoned
+	EventDropped
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventReceived:
+		return "received"
+	case EventSpent:
+		return "spent"
+	case EventUnlocked:
+		return "unlocked"
+	case EventConfirmed:
+		return "confirmed"
+	case EventRollback:
+		return "rollback"
+	case EventSyncProgress:
+		return "sync progress"
+	case EventDropped:
+		return "dropped"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change to the wallet. Fields which do not apply to the
+// event type are left empty.
+type Event struct {
+	Type EventType
+	// Height is the height of the block which caused the event. For
+	// rollbacks, it is the first height which was discarded
+	Height uint64
+	// TxID is the id of the tx which created, spent, confirmed or dropped
+	// funds
+	TxID []byte
+	// PubKey is the one-time pubkey of the output the event is about
+	PubKey ristretto.Point
+	// Amount is the amount of the output, or the net amount of a
+	// confirmed or dropped tx
+	Amount uint64
+	// LockReason tells why a received output is locked, or why an
+	// unlocked output used to be
+	LockReason transactions.LockReason
+	// Change is set for received outputs which are the change of a tx of
+	// the wallet, rather than a payment
+	Change bool
+	// Progress is set for sync progress events
+	Progress SyncProgress
+}
+
+// eventBus delivers events to the wallet's subscribers. The zero value is
+// ready to use.
+type eventBus struct {
+	lock sync.RWMutex
+	next int
+	subs map[int]func(Event)
+}
+
+// Subscribe calls `f` for every event published by the wallet, until the
+// returned function is called. Events are delivered synchronously, in the
+// order they happen, once the change they describe is stored. `f` should
+// therefore return quickly, and must not call back into the wallet.
+func (w *Wallet) Subscribe(f func(Event)) (unsubscribe func()) {
+	w.events.lock.Lock()
+	defer w.events.lock.Unlock()
+
+	if w.events.subs == nil {
+		w.events.subs = make(map[int]func(Event))
+	}
+
+	id := w.events.next
+	w.events.next++
+	w.events.subs[id] = f
+
+	return func() {
+		w.events.lock.Lock()
+		defer w.events.lock.Unlock()
+		delete(w.events.subs, id)
+	}
+}
+
+// SubscribeChan is like Subscribe, but delivers the events on a channel
+// with the given buffer size. Events are dropped while the buffer is full.
+// The channel is closed when unsubscribing.
+func (w *Wallet) SubscribeChan(buffer int) (<-chan Event, func()) {
+	c := make(chan Event, buffer)
+
+	var lock sync.Mutex
+	closed := false
+	unsubscribe := w.Subscribe(func(e Event) {
+		lock.Lock()
+		defer lock.Unlock()
+		if closed {
+			return
+		}
+
+		select {
+		case c <- e:
+		default:
+		}
+	})
+
+	return c, func() {
+		unsubscribe()
+
+		lock.Lock()
+		defer lock.Unlock()
+		if !closed {
+			closed = true
+			close(c)
+		}
+	}
+}
+
+func (w *Wallet) publish(events ...Event) {
+	w.events.lock.RLock()
+	defer w.events.lock.RUnlock()
+
+	for _, e := range events {
+		for _, f := range w.events.subs {
+			f(e)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/dusk-network/dusk-wallet/v2/txrecords"
+)
+
+// ExportFormat is the file format used when exporting the tx history.
+type ExportFormat uint8
+
+const (
+	// CSV writes one comma separated row per record, after a header row
+	CSV ExportFormat = iota
+	// JSONLines writes one JSON object per line
+	JSONLines
+)
+
+var csvHeader = []string{
+	"txid", "state", "height", "time", "direction", "type",
+	"amount", "amount_dusk", "fee", "fee_dusk", "payment_id", "label",
+}
+
+// exportedRecord is the format a record is exported in. The user attached
+// labels of a record are its payment id, hex encoded, and its label. The
+// recipients, counterparties and inclusion proof are left out.
+type exportedRecord struct {
+	TxID  string `json:"txid"`
+	State string `json:"state"`
+	// Height is 0 for pending records, and Time is when they were recorded
+	Height     uint64 `json:"height"`
+	Time       string `json:"time"`
+	Direction  string `json:"direction"`
+	Type       string `json:"type"`
+	Amount     uint64 `json:"amount"`
+	AmountDusk string `json:"amount_dusk"`
+	Fee        uint64 `json:"fee"`
+	FeeDusk    string `json:"fee_dusk"`
+	PaymentID  string `json:"payment_id"`
+	Label      string `json:"label"`
+}
+
+func newExportedRecord(r txrecords.TxRecord) exportedRecord {
+	return exportedRecord{
+		TxID:       hex.EncodeToString(r.TxID),
+		State:      r.State.String(),
+		Height:     r.Height,
+		Time:       time.Unix(r.Timestamp, 0).UTC().Format(time.RFC3339),
+		Direction:  r.Direction.String(),
+		Type:       r.TxType.String(),
+		Amount:     r.Amount,
+		AmountDusk: formatDusk(r.Amount),
+		Fee:        r.Fee,
+		FeeDusk:    formatDusk(r.Fee),
+		PaymentID:  hex.EncodeToString(r.PaymentID),
+		Label:      r.Label,
+	}
+}
+
+// formatDusk formats an amount in atomic units as whole DUSK.
+func formatDusk(amount uint64) string {
+	return fmt.Sprintf("%d.%08d", amount/DUSK, amount%DUSK)
+}
+
+// ExportTxHistory writes the records of all txs with a timestamp between
+// `from` and `to`, inclusive, to `out`. The records are ordered by time.
+// A `to` of 0 exports everything after `from`. Pending records are exported
+// too, and can be told apart by their state.
+func (w *Wallet) ExportTxHistory(out io.Writer, format ExportFormat, from, to int64) error {
+	records, _, err := w.QueryTxHistory(txrecords.Query{
+		FromTime: from,
+		ToTime:   to,
+		SortBy:   txrecords.ByTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case CSV:
+		return WriteCSV(out, records)
+	case JSONLines:
+		return WriteJSONLines(out, records)
+	default:
+		return fmt.Errorf("unknown export format %d", format)
+	}
+}
+
+// WriteCSV writes `records` to `out` as CSV, with a header row.
+func WriteCSV(out io.Writer, records []txrecords.TxRecord) error {
+	cw := csv.NewWriter(out)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		e := newExportedRecord(r)
+		row := []string{
+			e.TxID,
+			e.State,
+			strconv.FormatUint(e.Height, 10),
+			e.Time,
+			e.Direction,
+			e.Type,
+			strconv.FormatUint(e.Amount, 10),
+			e.AmountDusk,
+			strconv.FormatUint(e.Fee, 10),
+			e.FeeDusk,
+			e.PaymentID,
+			e.Label,
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSONLines writes `records` to `out` as JSON Lines.
+func WriteJSONLines(out io.Writer, records []txrecords.TxRecord) error {
+	enc := json.NewEncoder(out)
+	for _, r := range records {
+		if err := enc.Encode(newExportedRecord(r)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
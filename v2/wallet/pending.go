@@ -0,0 +1,247 @@
+package wallet
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dusk-network/dusk-wallet/v2/block"
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+	"github.com/dusk-network/dusk-wallet/v2/txrecords"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// BalanceSummary holds the balance of the wallet, including the funds
+// which are still waiting to be included in a block.
+type BalanceSummary struct {
+	Unlocked uint64
+	Locked   uint64
+	// Confirming is the part of the locked balance which only waits for
+	// more confirmations, as required by the ConfirmationPolicy
+	Confirming uint64
+	// PendingIncoming is the amount received by pending txs
+	PendingIncoming uint64
+	// PendingOutgoing is the amount sent by pending txs of the wallet,
+	// fees included
+	PendingOutgoing uint64
+}
+
+// AddPendingTx records `tx`, which was broadcast by the wallet or seen in
+// the mempool, if it moves funds from or to the wallet. The record stays
+// pending until the tx is included in a block, is dropped once a block
+// spends one of its inputs in another tx, or is abandoned with
+// AbandonPendingTx. It returns whether the tx was recorded.
+func (w *Wallet) AddPendingTx(tx transactions.Transaction) (bool, error) {
+	txID, err := tx.CalculateHash()
+	if err != nil {
+		return false, err
+	}
+
+	// Known txs are either pending already, or confirmed
+	_, err = w.db.GetTxRecord(txID)
+	if err == nil {
+		return false, nil
+	}
+	if err != leveldb.ErrNotFound {
+		return false, err
+	}
+
+	owned := make(map[uint32]uint64)
+	for _, output := range w.scanOutputs(tx) {
+		owned[output.index] = output.amount.BigInt().Uint64()
+	}
+
+	var spent uint64
+	keyImages := make([][]byte, 0, len(tx.StandardTx().Inputs))
+	for _, input := range tx.StandardTx().Inputs {
+		keyImage := input.KeyImage.Bytes()
+		keyImages = append(keyImages, keyImage)
+
+		// The key images of the wallet's own txs are kept until the tx is
+		// included in a block
+		_, amount, err := w.db.GetKeyImage(keyImage)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		spent += amount
+	}
+
+	if len(owned) == 0 && spent == 0 {
+		return false, nil
+	}
+
+	txRecord, err := txrecords.New(tx, 0, time.Now().Unix(), nil, owned, spent)
+	if err != nil {
+		return false, err
+	}
+
+	// The unlock height is only known once the tx is in a block
+	txRecord.State = txrecords.Pending
+	txRecord.UnlockHeight = 0
+
+	if err := w.db.PutPendingTx(txRecord, keyImages); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ErrNotPending is returned when abandoning a tx which is not pending.
+var ErrNotPending = errors.New("tx is not pending")
+
+// AbandonPendingTx gives up on the pending tx with id `txID`, for instance
+// because it left the mempool without being included in a block. Its
+// record is removed, and the outputs of the wallet it spends can be spent
+// again. If a block includes the tx after all, it is recorded like any other
+// tx found in a block.
+func (w *Wallet) AbandonPendingTx(txID []byte) error {
+	txRecord, err := w.db.GetTxRecord(txID)
+	if err == leveldb.ErrNotFound {
+		return ErrNotPending
+	}
+	if err != nil {
+		return err
+	}
+
+	err = w.db.AbandonPendingTx(txID)
+	if err == leveldb.ErrNotFound {
+		return ErrNotPending
+	}
+	if err != nil {
+		return err
+	}
+
+	w.publish(Event{
+		Type:   EventDropped,
+		TxID:   txID,
+		Amount: txRecord.Amount,
+	})
+	return nil
+}
+
+// CheckMempool records the txs of the mempool which move funds from or to
+// the wallet, as pending. It returns the amount of newly recorded txs.
+func (w *Wallet) CheckMempool(txs []transactions.Transaction) (uint64, error) {
+	var count uint64
+	for _, tx := range txs {
+		added, err := w.AddPendingTx(tx)
+		if err != nil {
+			return count, err
+		}
+
+		if added {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// PendingTxs returns the records of all pending txs.
+func (w *Wallet) PendingTxs() ([]txrecords.TxRecord, error) {
+	pending, err := w.db.FetchPendingTxs()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]txrecords.TxRecord, 0, len(pending))
+	for _, p := range pending {
+		txRecord, err := w.db.GetTxRecord(p.TxID)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, *txRecord)
+	}
+
+	return records, nil
+}
+
+// BalanceSummary returns the locked and unlocked balance of the wallet,
+// along with the totals of the pending txs.
+func (w *Wallet) BalanceSummary() (BalanceSummary, error) {
+	privSpend, err := w.keyPair.PrivateSpend()
+	if err != nil {
+		return BalanceSummary{}, err
+	}
+
+	var s BalanceSummary
+	s.Unlocked, s.Locked, err = w.db.FetchBalance(privSpend.Bytes())
+	if err != nil {
+		return BalanceSummary{}, err
+	}
+
+	s.Confirming, err = w.confirmingBalance()
+	if err != nil {
+		return BalanceSummary{}, err
+	}
+
+	s.PendingIncoming, s.PendingOutgoing, err = w.db.FetchPendingBalance()
+	if err != nil {
+		return BalanceSummary{}, err
+	}
+
+	return s, nil
+}
+
+// reconcilePending removes the pending txs which were included in `blk`,
+// and drops the ones conflicting with it. The records of included txs are
+// confirmed while committing the block. Drop events are returned.
+func (w *Wallet) reconcilePending(blk block.Block) ([]Event, error) {
+	pending, err := w.db.FetchPendingTxs()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	txIDs := make(map[string]bool, len(blk.Txs))
+	keyImages := make(map[string]bool)
+	for _, tx := range blk.Txs {
+		txID, err := tx.CalculateHash()
+		if err != nil {
+			return nil, err
+		}
+		txIDs[string(txID)] = true
+
+		for _, input := range tx.StandardTx().Inputs {
+			keyImages[string(input.KeyImage.Bytes())] = true
+		}
+	}
+
+	var events []Event
+	for _, p := range pending {
+		if txIDs[string(p.TxID)] {
+			if err := w.db.ConfirmPendingTx(p.TxID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		for _, keyImage := range p.KeyImages {
+			if !keyImages[string(keyImage)] {
+				continue
+			}
+
+			txRecord, err := w.db.GetTxRecord(p.TxID)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := w.db.DropPendingTx(p.TxID); err != nil {
+				return nil, err
+			}
+
+			events = append(events, Event{
+				Type:   EventDropped,
+				Height: blk.Header.Height,
+				TxID:   p.TxID,
+				Amount: txRecord.Amount,
+			})
+			break
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,28 @@
+package wallet
+
+import (
+	"github.com/dusk-network/dusk-wallet/v2/block"
+)
+
+// blockScan holds the outputs of a block which belong to the wallet.
+type blockScan struct {
+	blk block.Block
+	// received holds the owned outputs of every tx, by tx index
+	received [][]receivedOutput
+}
+
+// scanBlock checks which outputs of `blk` belong to the wallet. This is the
+// expensive part of processing a block, and as it does not touch the
+// database, blocks can be scanned concurrently and in any order.
+func (w *Wallet) scanBlock(blk block.Block) *blockScan {
+	scan := &blockScan{
+		blk:      blk,
+		received: make([][]receivedOutput, len(blk.Txs)),
+	}
+
+	for i, tx := range blk.Txs {
+		scan.received[i] = w.scanOutputs(tx)
+	}
+
+	return scan
+}
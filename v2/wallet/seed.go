@@ -1,9 +1,12 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
@@ -11,8 +14,23 @@ import (
 	"golang.org/x/crypto/sha3"
 )
 
-// Save saves the seed to a dat file
-func saveSeed(seed []byte, password string, file string) error {
+// seedFileMagic starts the seed files which hold metadata along with the
+// seed. Older files only hold the encrypted seed.
+var seedFileMagic = []byte("DUSKSEED")
+
+// seedFileVersion is written after the magic. Version 1 files hold the
+// wallet birthday in front of the seed.
+const seedFileVersion uint8 = 1
+
+// seedMetadata is stored along with the seed.
+type seedMetadata struct {
+	// Birthday is the height of the chain when the wallet was created. No
+	// funds can have been sent to the wallet before it
+	Birthday uint64
+}
+
+// Save saves the seed to a dat file, along with its metadata
+func saveSeed(seed []byte, meta seedMetadata, password string, file string) error {
 	// Overwriting a seed file may cause loss of funds
 	if _, err := os.Stat(file); err == nil {
 		return ErrSeedFileExists
@@ -35,34 +53,61 @@ func saveSeed(seed []byte, password string, file string) error {
 		return err
 	}
 
-	return ioutil.WriteFile(file, gcm.Seal(nonce, nonce, seed, nil), 0777)
+	plaintext := make([]byte, 8, 8+len(seed))
+	binary.LittleEndian.PutUint64(plaintext, meta.Birthday)
+	plaintext = append(plaintext, seed...)
+
+	// The header is authenticated along with the seed, so that it can not be
+	// stripped to have the file read as one without metadata
+	header := append(append([]byte{}, seedFileMagic...), seedFileVersion)
+	return ioutil.WriteFile(file, gcm.Seal(append(header, nonce...), nonce, plaintext, header), 0777)
 }
 
 //Modified from https://tutorialedge.net/golang/go-encrypt-decrypt-aes-tutorial/
-func fetchSeed(password string, file string) ([]byte, error) {
+func fetchSeed(password string, file string) ([]byte, seedMetadata, error) {
 
 	digest := sha3.Sum256([]byte(password))
 
 	ciphertext, err := ioutil.ReadFile(file)
 	if err != nil {
-		return nil, err
+		return nil, seedMetadata{}, err
 	}
 
 	c, err := aes.NewCipher(digest[:])
 	if err != nil {
-		return nil, err
+		return nil, seedMetadata{}, err
 	}
 
 	gcm, err := cipher.NewGCM(c)
 	if err != nil {
-		return nil, err
+		return nil, seedMetadata{}, err
+	}
+
+	var header []byte
+	withMetadata := bytes.HasPrefix(ciphertext, seedFileMagic)
+	if withMetadata {
+		headerSize := len(seedFileMagic) + 1
+		if len(ciphertext) < headerSize || ciphertext[headerSize-1] != seedFileVersion {
+			return nil, seedMetadata{}, errors.New("unknown seed file version")
+		}
+		header, ciphertext = ciphertext[:headerSize], ciphertext[headerSize:]
 	}
 
 	nonceSize := gcm.NonceSize()
 	if len(ciphertext) < nonceSize {
-		return nil, err
+		return nil, seedMetadata{}, errors.New("seed file is too short")
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	return gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, header)
+	if err != nil || !withMetadata {
+		return plaintext, seedMetadata{}, err
+	}
+
+	if len(plaintext) < 8 {
+		return nil, seedMetadata{}, errors.New("seed file is too short")
+	}
+
+	meta := seedMetadata{Birthday: binary.LittleEndian.Uint64(plaintext)}
+	return plaintext[8:], meta, nil
 }
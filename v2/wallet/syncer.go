@@ -0,0 +1,289 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/dusk-network/dusk-wallet/v2/block"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// SyncProgress is reported by the Syncer after every processed block.
+type SyncProgress struct {
+	// Height is the height of the block which was just processed
+	Height uint64
+	// Tip is the height the wallet is syncing up to
+	Tip uint64
+	// Spent and Received count the outputs spent and received in the block
+	Spent, Received uint64
+}
+
+// Syncer catches a wallet up with the blocks of a BlockSource.
+type Syncer struct {
+	w        *Wallet
+	source   BlockSource
+	progress func(SyncProgress)
+
+	// MaxRetries is the amount of times a request to the block source
+	// failing with a transient error, like TransientError, is retried
+	// before giving up
+	MaxRetries int
+	// RetryDelay is the time waited before the first retry. It doubles with
+	// every following retry
+	RetryDelay time.Duration
+	// Workers is the amount of blocks fetched and scanned concurrently
+	Workers int
+}
+
+// NewSyncer returns a Syncer which feeds the blocks of `source` to `w`.
+// If `progress` is not nil, it is called after every processed block. The
+// progress is published to the wallet's subscribers as well.
+func NewSyncer(w *Wallet, source BlockSource, progress func(SyncProgress)) *Syncer {
+	return &Syncer{
+		w:          w,
+		source:     source,
+		progress:   progress,
+		MaxRetries: 5,
+		RetryDelay: 100 * time.Millisecond,
+		Workers:    runtime.NumCPU(),
+	}
+}
+
+// ErrForkTooDeep is returned by the Syncer when the chain of the block
+// source forked from the wallet's chain below the stored header hashes.
+var ErrForkTooDeep = errors.New("chain forked below the stored header hashes")
+
+// Sync processes all blocks from the saved wallet height up to the tip of
+// the block source. It returns early with the context error if `ctx` is
+// cancelled.
+//
+// Blocks are fetched and scanned for owned outputs by a pool of workers,
+// and committed to the database one at a time, in height order. An
+// interrupted sync therefore resumes right after the last committed block.
+//
+// If the chain of the block source forked from the blocks processed by the
+// wallet, the wallet is rolled back to the last common block, and the sync
+// continues on the new chain.
+func (s *Syncer) Sync(ctx context.Context) error {
+	err := s.sync(ctx)
+	if err != block.ErrPrevBlockMismatch {
+		return err
+	}
+
+	if err := s.rollback(ctx); err != nil {
+		return err
+	}
+
+	// A source which still does not link up is not trusted any further
+	return s.sync(ctx)
+}
+
+// rollback finds the last block the wallet has in common with the block
+// source, and rescans from the block after it.
+func (s *Syncer) rollback(ctx context.Context) error {
+	height, err := s.w.GetSavedHeight()
+	if err != nil {
+		return err
+	}
+
+	for h := height; h > 0 && height-h < HeaderHistory; h-- {
+		hash, err := s.w.db.GetHeaderHash(h - 1)
+		if err == leveldb.ErrNotFound {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var blk *block.Block
+		err = s.retry(ctx, func() error {
+			var err error
+			blk, err = s.source.BlockAtHeight(ctx, h-1)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(blk.Header.Hash, hash) {
+			return s.w.Rescan(h)
+		}
+	}
+
+	return ErrForkTooDeep
+}
+
+func (s *Syncer) sync(ctx context.Context) error {
+	var tip uint64
+	err := s.retry(ctx, func() error {
+		var err error
+		tip, err = s.source.TipHeight(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	height, err := s.w.GetSavedHeight()
+	if err != nil {
+		return err
+	}
+
+	if height > tip {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Every job gets its own result channel. The channels are queued in
+	// height order, which is the order the results are committed in. The
+	// queue bounds how far the workers can run ahead of the commits.
+	jobs := make(chan scanJob)
+	queue := make(chan chan scanResult, 2*workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				job.result <- s.scan(ctx, job.height)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(queue)
+		defer close(jobs)
+		for h := height; h <= tip; h++ {
+			job := scanJob{h, make(chan scanResult, 1)}
+			select {
+			case queue <- job.result:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Stop the workers before returning
+	defer wg.Wait()
+	defer cancel()
+
+	for result := range queue {
+		var r scanResult
+		select {
+		case r = <-result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if r.err != nil {
+			return r.err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		spent, received, err := s.w.commitBlock(r.scan)
+		if err != nil {
+			return err
+		}
+
+		progress := SyncProgress{
+			Height:   r.scan.blk.Header.Height,
+			Tip:      tip,
+			Spent:    spent,
+			Received: received,
+		}
+
+		if s.progress != nil {
+			s.progress(progress)
+		}
+
+		s.w.publish(Event{Type: EventSyncProgress, Height: progress.Height, Progress: progress})
+	}
+
+	return ctx.Err()
+}
+
+// Rescan discards what the wallet learnt from the blocks at or above
+// `fromHeight`, and syncs it again, reporting the progress as Sync does.
+func (s *Syncer) Rescan(ctx context.Context, fromHeight uint64) error {
+	if err := s.w.Rescan(fromHeight); err != nil {
+		return err
+	}
+
+	return s.Sync(ctx)
+}
+
+type scanJob struct {
+	height uint64
+	result chan scanResult
+}
+
+type scanResult struct {
+	scan *blockScan
+	err  error
+}
+
+// scan fetches the block at `height` and scans it.
+func (s *Syncer) scan(ctx context.Context, height uint64) scanResult {
+	var blk *block.Block
+	err := s.retry(ctx, func() error {
+		var err error
+		blk, err = s.source.BlockAtHeight(ctx, height)
+		return err
+	})
+	if err != nil {
+		return scanResult{err: err}
+	}
+
+	return scanResult{scan: s.w.scanBlock(*blk)}
+}
+
+// retry calls `f` until it succeeds, the retries run out or `ctx` is done.
+// Only transient errors are retried, any other error is returned right
+// away, and so is the context error once `ctx` is done.
+func (s *Syncer) retry(ctx context.Context, f func() error) error {
+	delay := s.RetryDelay
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+
+		// Requests fail once the context is done, which is not transient
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !isTransient(err) || i >= s.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"fmt"
 	"math/big"
 
 	ristretto "github.com/bwesterb/go-ristretto"
@@ -16,6 +17,17 @@ func (w *Wallet) NewStandardTx(fee int64) (*transactions.Standard, error) {
 	return tx, nil
 }
 
+// NewTimelockTx creates a transaction which pays out funds that stay locked
+// for `lockTime` blocks after the transaction is included in a block. The
+// change of the transaction is not locked.
+func (w *Wallet) NewTimelockTx(fee int64, lockTime uint64) (*transactions.Timelock, error) {
+	if lockTime > transactions.MaxLockTime {
+		return nil, fmt.Errorf("lock time cannot exceed %d blocks", transactions.MaxLockTime)
+	}
+
+	return transactions.NewTimelock(0, w.netPrefix, fee, lockTime)
+}
+
 func (w *Wallet) NewStakeTx(fee int64, lockTime uint64, amount ristretto.Scalar) (*transactions.Stake, error) {
 	edPubBytes := w.consensusKeys.EdPubKeyBytes
 	blsPubBytes := w.consensusKeys.BLSPubKeyBytes
@@ -69,30 +81,62 @@ func (w *Wallet) AddInputs(tx *transactions.Standard) error {
 		}
 	}
 
+	return w.addChange(tx, changeAmount)
+}
+
+// addChange sends `changeAmount` back to the wallet, splitting it up
+// according to the wallet's ChangePolicy.
+func (w *Wallet) addChange(tx *transactions.Standard, changeAmount int64) error {
 	changeAddr, err := w.keyPair.PublicKey().PublicAddress(w.netPrefix)
 	if err != nil {
 		return err
 	}
 
-	// Convert int64 to ristretto value
-	var x ristretto.Scalar
-	x.SetBigInt(big.NewInt(changeAmount))
+	for _, amount := range w.changePolicy.split(changeAmount, transactions.MaxOutputs-len(tx.Outputs)) {
+		// Convert int64 to ristretto value
+		var x ristretto.Scalar
+		x.SetBigInt(big.NewInt(amount))
 
-	return tx.AddOutput(*changeAddr, x)
+		if err := tx.AddOutput(*changeAddr, x); err != nil {
+			return err
+		}
+
+		// Remember the output is change, to tell it apart from payments
+		// to our own address once the tx is in a block
+		if err := w.db.PutChangeOutput(tx.Outputs[len(tx.Outputs)-1].PubKey.P.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (w *Wallet) Sign(tx SignableTx) error {
+// Sign adds inputs and decoys to the transaction, and creates its proofs.
+// If `outputs` are given, only the owned outputs with these one-time pubkeys
+// are spent. Otherwise, inputs are picked by the wallet's FetchInputs function.
+func (w *Wallet) Sign(tx SignableTx, outputs ...ristretto.Point) error {
 	// Assuming user has added all of the outputs
 	standardTx := tx.StandardTx()
 
 	// Fetch Inputs
-	err := w.AddInputs(standardTx)
+	var err error
+	if len(outputs) > 0 {
+		err = w.addSelectedInputs(standardTx, outputs)
+	} else {
+		err = w.AddInputs(standardTx)
+	}
 	if err != nil {
 		return err
 	}
 
+	return w.prove(tx)
+}
+
+// prove adds the decoys to a transaction which has all of its inputs and
+// outputs set, and creates its proofs.
+func (w *Wallet) prove(tx SignableTx) error {
 	// Fetch decoys
-	err = standardTx.AddDecoys(numMixins, w.fetchDecoys)
+	err := tx.StandardTx().AddDecoys(numMixins, w.fetchDecoys)
 	if err != nil {
 		return err
 	}
@@ -102,7 +146,9 @@ func (w *Wallet) Sign(tx SignableTx) error {
 	}
 
 	// Remove inputs from the db, to prevent accidental double-spend attempts
-	// when sending transactions quickly after one another.
+	// when sending transactions quickly after one another. The key images
+	// are kept, so that we can tell how much we spent once the tx is
+	// included in a block.
 	for _, input := range tx.StandardTx().Inputs {
 		outputKey, err := w.db.GetPubKey(input.KeyImage.Bytes())
 		if err == leveldb.ErrNotFound {
@@ -112,7 +158,9 @@ func (w *Wallet) Sign(tx SignableTx) error {
 			return err
 		}
 
-		w.db.RemoveInput(outputKey, input.KeyImage.Bytes())
+		if err := w.db.DeleteInput(outputKey); err != nil {
+			return err
+		}
 	}
 
 	return nil
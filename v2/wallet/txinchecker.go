@@ -29,50 +29,84 @@ func NewTxInChecker(txs []transactions.Transaction) []TxInChecker {
 }
 
 // CheckWireBlockSpent checks if the block has any outputs spent by this wallet
-// Returns the number of txs that the sender spent funds in
+// Returns the number of inputs this wallet spent in the block
+// The txs spending them are recorded with their net amount, which includes
+// the outputs they send back to the wallet. CheckWireBlock does the same,
+// and checks the block header as well.
 func (w *Wallet) CheckWireBlockSpent(blk block.Block) (uint64, error) {
 	var totalSpentCount uint64
+	var records []*txrecords.TxRecord
 	txInCheckers := NewTxInChecker(blk.Txs)
-	privView, err := w.keyPair.PrivateView()
-	if err != nil {
-		return 0, err
-	}
 
 	for i, txchecker := range txInCheckers {
-		spentCount, err := w.removeSpentOutputs(txchecker)
+		spent, err := w.removeSpentOutputs(txchecker, blk.Header.Height)
+		totalSpentCount += uint64(len(spent))
 		if err != nil {
-			return spentCount, err
+			return totalSpentCount, err
 		}
-		totalSpentCount += spentCount
 
-		if spentCount > 0 {
-			_ = w.db.PutTxRecord(blk.Txs[i], txrecords.Out, privView)
+		if len(spent) == 0 {
+			continue
 		}
+
+		// The outputs sent back are found whether or not the block was
+		// checked by CheckWireBlockReceived before
+		owned := make(map[uint32]uint64)
+		for _, r := range w.scanOutputs(blk.Txs[i]) {
+			owned[r.index] = r.amount.BigInt().Uint64()
+		}
+
+		txRecord, err := w.newTxRecord(blk.Txs[i], blk, owned, totalAmount(spent))
+		if err != nil {
+			return totalSpentCount, err
+		}
+
+		records = append(records, txRecord)
+	}
+
+	if _, err := w.putTxRecords(blk, records); err != nil {
+		return totalSpentCount, err
 	}
 
 	return totalSpentCount, nil
 }
 
+// spentOutput is an owned output which was spent.
+type spentOutput struct {
+	pubKey []byte
+	amount uint64
+}
+
+func totalAmount(outputs []spentOutput) uint64 {
+	var total uint64
+	for _, output := range outputs {
+		total += output.amount
+	}
+
+	return total
+}
+
 // Given a tx checker, this function will remove the inputs associated
 // with the keyimages found in the tx checker, as they are now confirmed
-// to be spent.
-func (w *Wallet) removeSpentOutputs(txChecker TxInChecker) (uint64, error) {
-	var didSpendFunds uint64
+// to be spent in the block at `height`. It returns the outputs which were
+// removed.
+func (w *Wallet) removeSpentOutputs(txChecker TxInChecker, height uint64) ([]spentOutput, error) {
+	var spent []spentOutput
 	for _, keyImage := range txChecker.keyImages {
-		outputKey, err := w.db.GetPubKey(keyImage)
+		outputKey, amount, err := w.db.GetKeyImage(keyImage)
 		if err == leveldb.ErrNotFound {
 			continue
 		}
 		if err != nil {
-			return didSpendFunds, err
+			return spent, err
 		}
 
-		didSpendFunds++
-
-		if err := w.db.RemoveInput(outputKey, keyImage); err != nil {
-			return didSpendFunds, err
+		if err := w.db.SpendInput(outputKey, keyImage, height); err != nil {
+			return spent, err
 		}
+
+		spent = append(spent, spentOutput{outputKey, amount})
 	}
 
-	return didSpendFunds, nil
+	return spent, nil
 }
@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"bytes"
 	"math/rand"
 
 	"github.com/bwesterb/go-ristretto"
@@ -9,74 +10,237 @@ import (
 	"github.com/dusk-network/dusk-wallet/v2/key"
 	"github.com/dusk-network/dusk-wallet/v2/transactions"
 	"github.com/dusk-network/dusk-wallet/v2/txrecords"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 // CheckWireBlockReceived checks if the wire block has transactions for this wallet
 // Returns the number of tx's that the reciever recieved funds in
+// The txs are recorded with their net amount. Outputs are only recognised
+// as change, and the amount spent by the wallet is only known, if the block
+// is checked before CheckWireBlockSpent. Otherwise, the records stored by
+// CheckWireBlockSpent are kept. CheckWireBlock does not depend on the order,
+// and checks the block header as well.
 func (w *Wallet) CheckWireBlockReceived(blk block.Block) (uint64, error) {
-	privView, err := w.keyPair.PrivateView()
-	if err != nil {
+	var totalReceivedCount uint64
+	var records []*txrecords.TxRecord
+
+	for _, tx := range blk.Txs {
+		owned, spent, err := w.receiveOutputs(tx, blk.Header.Height)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(owned) == 0 {
+			continue
+		}
+
+		totalReceivedCount++
+
+		txRecord, err := w.newTxRecord(tx, blk, owned, totalAmount(spent))
+		if err != nil {
+			return 0, err
+		}
+
+		old, err := w.db.GetTxRecord(txRecord.TxID)
+		if err != nil && err != leveldb.ErrNotFound {
+			return 0, err
+		}
+
+		// Recorded by CheckWireBlockSpent, along with the spent amount
+		if err == nil && old.State == txrecords.Confirmed && bytes.Equal(old.BlockHash, blk.Header.Hash) {
+			continue
+		}
+
+		records = append(records, txRecord)
+	}
+
+	if _, err := w.putTxRecords(blk, records); err != nil {
 		return 0, err
 	}
 
+	return totalReceivedCount, nil
+}
+
+// receivedOutput is an output found to belong to the wallet.
+type receivedOutput struct {
+	index                 uint32
+	amount, mask, privKey ristretto.Scalar
+	keyImage              ristretto.Point
+}
+
+// receiveOutputs stores all outputs of `tx` which belong to this wallet, and
+// returns their amounts by output index, along with the outputs of the
+// wallet `tx` spends.
+func (w *Wallet) receiveOutputs(tx transactions.Transaction, blockHeight uint64) (map[uint32]uint64, []spentOutput, error) {
+	received := w.scanOutputs(tx)
+	if len(received) == 0 {
+		return map[uint32]uint64{}, nil, nil
+	}
+
+	spent, err := w.spentOwnedOutputs(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owned, _, err := w.storeOutputs(tx, received, blockHeight)
+	return owned, spent, err
+}
+
+// spentOwnedOutputs returns the outputs of the wallet spent by `tx`, which
+// makes the outputs it sends back to the wallet change. Only outputs which
+// were not removed as spent yet are found.
+func (w *Wallet) spentOwnedOutputs(tx transactions.Transaction) ([]spentOutput, error) {
+	var spent []spentOutput
+	for _, input := range tx.StandardTx().Inputs {
+		pubKey, amount, err := w.db.GetKeyImage(input.KeyImage.Bytes())
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		spent = append(spent, spentOutput{pubKey, amount})
+	}
+
+	return spent, nil
+}
+
+// scanOutputs returns the outputs of `tx` which belong to this wallet. It
+// does not touch the database, and can be called concurrently.
+func (w *Wallet) scanOutputs(tx transactions.Transaction) []receivedOutput {
+	// The shared secret is the same for all outputs, and is used both for
+	// the stealth address check and to decrypt the amount and mask
+	secret := w.keyPair.SharedSecret(tx.StandardTx().R)
+
+	hasViewTags := tx.StandardTx().Version >= transactions.ViewTagVersion
+
+	var received []receivedOutput
+	for i, output := range tx.StandardTx().Outputs {
+		// Skip most foreign outputs after a single hash
+		if hasViewTags && output.ViewTag != key.ViewTag(secret, uint32(i)) {
+			continue
+		}
+
+		privKey, ok := w.keyPair.DidReceiveTxWithSecret(secret, output.PubKey, uint32(i))
+		if !ok {
+			continue
+		}
+
+		amount, mask := decryptOutput(*output, tx, i, secret)
+
+		var pubKey ristretto.Point
+		pubKey.ScalarMultBase(privKey)
+		received = append(received, receivedOutput{
+			index:    uint32(i),
+			amount:   amount,
+			mask:     mask,
+			privKey:  *privKey,
+			keyImage: mlsag.CalculateKeyImage(*privKey, pubKey),
+		})
+	}
+
+	return received
+}
+
+// storeOutputs writes the outputs found by scanOutputs to the database, and
+// returns their amounts by output index, along with an event for each.
+// Outputs the wallet recorded as change when building `tx` are stored as
+// change.
+func (w *Wallet) storeOutputs(tx transactions.Transaction, received []receivedOutput, blockHeight uint64) (map[uint32]uint64, []Event, error) {
+	owned := make(map[uint32]uint64)
+	if len(received) == 0 {
+		return owned, nil, nil
+	}
+
 	privSpend, err := w.keyPair.PrivateSpend()
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
 
-	var totalReceivedCount uint64
+	txID, err := tx.CalculateHash()
+	if err != nil {
+		return nil, nil, err
+	}
 
-	for _, tx := range blk.Txs {
-		var didReceiveFunds bool
-		for i, output := range tx.StandardTx().Outputs {
-			privKey, ok := w.keyPair.DidReceiveTx(tx.StandardTx().R, output.PubKey, uint32(i))
-			if !ok {
-				continue
-			}
-
-			didReceiveFunds = true
-
-			if err := w.writeOutputToDatabase(*output, privView, privSpend, *privKey, tx, i, blk.Header.Height); err != nil {
-				return 0, err
-			}
-
-			if err := w.writeKeyImageToDatabase(*output, *privKey); err != nil {
-				return 0, err
-			}
+	events := make([]Event, 0, len(received))
+	for _, r := range received {
+		output := tx.StandardTx().Outputs[r.index]
+		change, err := w.db.IsChangeOutput(output.PubKey.P.Bytes())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		lockReason, err := w.writeOutputToDatabase(*output, r.amount, r.mask, privSpend, r.privKey, tx, txID, int(r.index), change, blockHeight)
+		if err != nil {
+			return nil, nil, err
 		}
 
-		if didReceiveFunds {
-			totalReceivedCount++
-			_ = w.db.PutTxRecord(tx, txrecords.In, privView)
+		amount := r.amount.BigInt().Uint64()
+
+		// cache the keyImage, so we can quickly check whether our input was spent
+		if err := w.db.PutKeyImage(r.keyImage.Bytes(), output.PubKey.P.Bytes(), amount); err != nil {
+			return nil, nil, err
 		}
+
+		owned[r.index] = amount
+		events = append(events, Event{
+			Type:       EventReceived,
+			Height:     blockHeight,
+			TxID:       txID,
+			PubKey:     output.PubKey.P,
+			Amount:     amount,
+			LockReason: lockReason,
+			Change:     change,
+		})
 	}
 
-	return totalReceivedCount, nil
+	return owned, events, nil
 }
 
-func (w *Wallet) writeOutputToDatabase(output transactions.Output, privView *key.PrivateView, privSpend *key.PrivateSpend, privKey ristretto.Scalar, tx transactions.Transaction, i int, blockHeight uint64) error {
+func decryptOutput(output transactions.Output, tx transactions.Transaction, i int, secret ristretto.Point) (ristretto.Scalar, ristretto.Scalar) {
 	var amount, mask ristretto.Scalar
 	amount.Set(&output.EncryptedAmount)
 	mask.Set(&output.EncryptedMask)
 
 	if transactions.ShouldEncryptValues(tx) {
-		amount = transactions.DecryptAmount(output.EncryptedAmount, tx.StandardTx().R, uint32(i), *privView)
-		mask = transactions.DecryptMask(output.EncryptedMask, tx.StandardTx().R, uint32(i), *privView)
+		amount = transactions.DecryptAmountWithSecret(output.EncryptedAmount, secret, uint32(i))
+		mask = transactions.DecryptMaskWithSecret(output.EncryptedMask, secret, uint32(i))
+	}
+
+	return amount, mask
+}
+
+// writeOutputToDatabase stores an owned output, and returns why it is
+// locked, if it is. `isChange` is set for the change outputs of the wallet's
+// own txs.
+func (w *Wallet) writeOutputToDatabase(output transactions.Output, amount, mask ristretto.Scalar, privSpend *key.PrivateSpend, privKey ristretto.Scalar, tx transactions.Transaction, txID []byte, i int, isChange bool, blockHeight uint64) (transactions.LockReason, error) {
+	// Change sent back to us by our own transactions is never locked up
+	// by a Timelock.
+	role := transactions.PaymentOutput
+	if isChange {
+		role = transactions.ChangeOutput
 	}
 
-	// Only the first output of a tx is locked, to avoid locking up
-	// a change output.
-	if i == 0 {
-		return w.db.PutInput(privSpend.Bytes(), output.PubKey.P, amount, mask, privKey, tx.LockTime()+blockHeight, rand.Uint64())
+	var unlockHeight uint64
+	lockReason, lockTime := transactions.OutputLock(tx, uint32(i), role, w.coinbaseMaturity)
+	if lockReason != transactions.LockNone {
+		unlockHeight = blockHeight + lockTime
 	}
 
-	return w.db.PutInput(privSpend.Bytes(), output.PubKey.P, amount, mask, privKey, 0, rand.Uint64())
-}
+	// The output can only be spent once it has enough confirmations. The
+	// block including it gives it the first one.
+	confirmations := w.confirmationPolicy.Received
+	if isChange {
+		confirmations = w.confirmationPolicy.Change
+	}
+
+	if confirmations > 1 && blockHeight+confirmations-1 > unlockHeight {
+		unlockHeight = blockHeight + confirmations - 1
+		if lockReason == transactions.LockNone {
+			lockReason = transactions.LockConfirmations
+		}
+	}
 
-func (w *Wallet) writeKeyImageToDatabase(output transactions.Output, privKey ristretto.Scalar) error {
-	// cache the keyImage, so we can quickly check whether our input was spent
-	var pubKey ristretto.Point
-	pubKey.ScalarMultBase(&privKey)
-	keyImage := mlsag.CalculateKeyImage(privKey, pubKey)
-	return w.db.PutKeyImage(keyImage.Bytes(), output.PubKey.P.Bytes())
+	return lockReason, w.db.PutInput(privSpend.Bytes(), output.PubKey.P, amount, mask, privKey, unlockHeight, lockReason, txID, blockHeight, rand.Uint64())
 }
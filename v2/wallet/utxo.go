@@ -0,0 +1,133 @@
+package wallet
+
+import (
+	"errors"
+	"math/big"
+
+	ristretto "github.com/bwesterb/go-ristretto"
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+)
+
+// ChangePolicy decides how the change of a transaction is sent back to the
+// wallet. The zero value sends all change to a single output.
+type ChangePolicy struct {
+	// SplitAbove is the largest amount a single change output may hold.
+	// Any change above it is spread over several outputs. Zero disables
+	// splitting.
+	SplitAbove uint64
+	// MaxOutputs caps the amount of outputs the change is split into.
+	MaxOutputs int
+}
+
+// split divides `change` into the amounts of the change outputs, using at
+// most `free` outputs.
+func (p ChangePolicy) split(change int64, free int) []int64 {
+	n := 1
+	if p.SplitAbove > 0 && change > 0 {
+		n = int((uint64(change) + p.SplitAbove - 1) / p.SplitAbove)
+	}
+
+	if p.MaxOutputs > 0 && n > p.MaxOutputs {
+		n = p.MaxOutputs
+	}
+
+	if n > free {
+		n = free
+	}
+
+	if n < 1 {
+		n = 1
+	}
+
+	amounts := make([]int64, n)
+	for i := range amounts {
+		amounts[i] = change / int64(n)
+	}
+
+	// Put the remainder in the last output
+	amounts[n-1] += change % int64(n)
+	return amounts
+}
+
+// SetChangePolicy sets the policy used for sending change back to the wallet.
+func (w *Wallet) SetChangePolicy(p ChangePolicy) {
+	w.changePolicy = p
+}
+
+// Consolidate merges all unlocked inputs worth less than `threshold` into
+// single outputs sent back to the wallet. Each transaction spends up to
+// `transactions.MaxInputs` inputs and pays `fee`, and no more transactions
+// are created than `feeBudget` allows for.
+func (w *Wallet) Consolidate(threshold, fee, feeBudget int64) ([]*transactions.Standard, error) {
+	if fee < 0 || feeBudget < 0 {
+		return nil, errors.New("fee cannot be negative")
+	}
+
+	privSpend, err := w.keyPair.PrivateSpend()
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := w.db.FetchDustInputs(privSpend.Bytes(), threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	walletAddr, err := w.keyPair.PublicKey().PublicAddress(w.netPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []*transactions.Standard
+	// A single input can not be merged with anything
+	for len(inputs) > 1 && feeBudget >= fee {
+		n := transactions.MaxInputs
+		if n > len(inputs) {
+			n = len(inputs)
+		}
+
+		var total ristretto.Scalar
+		total.SetZero()
+		for _, input := range inputs[:n] {
+			amount := input.Amount()
+			total.Add(&total, &amount)
+		}
+
+		// Not worth consolidating
+		if total.BigInt().Int64() <= fee {
+			break
+		}
+
+		tx, err := w.NewStandardTx(fee)
+		if err != nil {
+			return txs, err
+		}
+
+		for _, input := range inputs[:n] {
+			if err := tx.AddInput(input); err != nil {
+				return txs, err
+			}
+		}
+
+		var amount ristretto.Scalar
+		amount.SetBigInt(big.NewInt(total.BigInt().Int64() - fee))
+		if err := tx.AddOutput(*walletAddr, amount); err != nil {
+			return txs, err
+		}
+
+		// The merged output is change, like the inputs it replaces
+		if err := w.db.PutChangeOutput(tx.Outputs[0].PubKey.P.Bytes()); err != nil {
+			return txs, err
+		}
+
+		if err := w.prove(tx); err != nil {
+			return txs, err
+		}
+
+		txs = append(txs, tx)
+		inputs = inputs[n:]
+		feeBudget -= fee
+	}
+
+	return txs, nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/dusk-network/dusk-wallet/v2/block"
 	"github.com/dusk-network/dusk-wallet/v2/database"
@@ -22,6 +23,26 @@ const DUSK = uint64(100000000)
 
 var ErrSeedFileExists = fmt.Errorf("wallet seed file already exists")
 
+// HeaderHistory is the amount of header hashes of the latest blocks kept by
+// the wallet, to detect forks.
+const HeaderHistory = 100
+
+// HeightMismatchError is returned for blocks which are not at the height
+// the wallet expects.
+type HeightMismatchError struct {
+	BlockHeight  uint64
+	WalletHeight uint64
+}
+
+func (e *HeightMismatchError) Error() string {
+	return fmt.Sprintf("mismatch between block height and wallet height\nblock height: %v - wallet height: %v\n", e.BlockHeight, e.WalletHeight)
+}
+
+// BirthdayMargin is the amount of blocks below its birthday a new wallet
+// starts syncing from, in case the birthday was taken from a node which
+// lagged behind.
+const BirthdayMargin = 1000
+
 // FetchInputs returns a slice of inputs such that Sum(Inputs)- Sum(Outputs) >= 0
 // If > 0, then a change address is created for the remaining amount
 type FetchInputs func(netPrefix byte, db *database.DB, totalAmount int64, key *key.Key) ([]*transactions.Input, int64, error)
@@ -35,6 +56,15 @@ type Wallet struct {
 
 	fetchDecoys transactions.FetchDecoys
 	fetchInputs FetchInputs
+
+	// birthday is the chain height when the wallet was created
+	birthday uint64
+
+	changePolicy       ChangePolicy
+	confirmationPolicy ConfirmationPolicy
+	coinbaseMaturity   uint64
+
+	events eventBus
 }
 
 type SignableTx interface {
@@ -44,6 +74,13 @@ type SignableTx interface {
 }
 
 func New(Read func(buf []byte) (n int, err error), netPrefix byte, db *database.DB, fDecoys transactions.FetchDecoys, fInputs FetchInputs, password string, file string) (*Wallet, error) {
+	return NewWithBirthday(Read, 0, netPrefix, db, fDecoys, fInputs, password, file)
+}
+
+// NewWithBirthday creates a wallet with a random seed, like New. `birthday`
+// is the current height of the chain. It is stored with the seed, and the
+// wallet does not scan the blocks below it.
+func NewWithBirthday(Read func(buf []byte) (n int, err error), birthday uint64, netPrefix byte, db *database.DB, fDecoys transactions.FetchDecoys, fInputs FetchInputs, password string, file string) (*Wallet, error) {
 
 	var seed []byte
 	for {
@@ -66,18 +103,40 @@ func New(Read func(buf []byte) (n int, err error), netPrefix byte, db *database.
 		// If not, we retry.
 	}
 
-	return LoadFromSeed(seed, netPrefix, db, fDecoys, fInputs, password, file)
+	return RestoreFromSeed(seed, birthday, netPrefix, db, fDecoys, fInputs, password, file)
 }
 
 func LoadFromSeed(seed []byte, netPrefix byte, db *database.DB, fDecoys transactions.FetchDecoys, fInputs FetchInputs, password string, file string) (*Wallet, error) {
+	return RestoreFromSeed(seed, 0, netPrefix, db, fDecoys, fInputs, password, file)
+}
+
+// RestoreFromSeed loads the wallet with the given seed, like LoadFromSeed.
+// `birthday` is the height of the chain when the wallet was created, which
+// is stored with the seed. A wallet with an empty database starts syncing
+// from BirthdayMargin blocks below it.
+func RestoreFromSeed(seed []byte, birthday uint64, netPrefix byte, db *database.DB, fDecoys transactions.FetchDecoys, fInputs FetchInputs, password string, file string) (*Wallet, error) {
 	if len(seed) < 64 {
 		return nil, errors.New("seed must be atleast 64 bytes in size")
 	}
-	err := saveSeed(seed, password, file)
+	err := saveSeed(seed, seedMetadata{Birthday: birthday}, password, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return load(seed, seedMetadata{Birthday: birthday}, netPrefix, db, fDecoys, fInputs)
+}
+
+func LoadFromFile(netPrefix byte, db *database.DB, fDecoys transactions.FetchDecoys, fInputs FetchInputs, password string, file string) (*Wallet, error) {
+
+	seed, meta, err := fetchSeed(password, file)
 	if err != nil {
 		return nil, err
 	}
 
+	return load(seed, meta, netPrefix, db, fDecoys, fInputs)
+}
+
+func load(seed []byte, meta seedMetadata, netPrefix byte, db *database.DB, fDecoys transactions.FetchDecoys, fInputs FetchInputs) (*Wallet, error) {
 	consensusKeys, err := generateConsensusKeys(seed)
 	if err != nil {
 		return nil, err
@@ -90,6 +149,7 @@ func LoadFromSeed(seed []byte, netPrefix byte, db *database.DB, fDecoys transact
 		consensusKeys: &consensusKeys,
 		fetchDecoys:   fDecoys,
 		fetchInputs:   fInputs,
+		birthday:      meta.Birthday,
 	}
 
 	// Check if this is a new wallet
@@ -102,8 +162,8 @@ func LoadFromSeed(seed []byte, netPrefix byte, db *database.DB, fDecoys transact
 		return nil, err
 	}
 
-	// Add height of zero into database
-	err = w.UpdateWalletHeight(0)
+	// Start scanning from the birthday
+	err = w.UpdateWalletHeight(w.startHeight())
 	if err != nil {
 		return nil, err
 	}
@@ -111,29 +171,35 @@ func LoadFromSeed(seed []byte, netPrefix byte, db *database.DB, fDecoys transact
 	return w, nil
 }
 
-func LoadFromFile(netPrefix byte, db *database.DB, fDecoys transactions.FetchDecoys, fInputs FetchInputs, password string, file string) (*Wallet, error) {
-
-	seed, err := fetchSeed(password, file)
-	if err != nil {
-		return nil, err
-	}
+// Birthday returns the height of the chain when the wallet was created.
+func (w *Wallet) Birthday() uint64 {
+	return w.birthday
+}
 
-	consensusKeys, err := generateConsensusKeys(seed)
-	if err != nil {
-		return nil, err
+// startHeight returns the height a wallet without any state starts
+// syncing from.
+func (w *Wallet) startHeight() uint64 {
+	if w.birthday < BirthdayMargin {
+		return 0
 	}
 
-	return &Wallet{
-		db:            db,
-		netPrefix:     netPrefix,
-		keyPair:       key.NewKeyPair(seed),
-		consensusKeys: &consensusKeys,
-		fetchDecoys:   fDecoys,
-		fetchInputs:   fInputs,
-	}, nil
+	return w.birthday - BirthdayMargin
 }
 
+// CheckWireBlock processes the block at the wallet height, and returns the
+// amount of owned outputs it spent, and of txs paying the wallet. The block
+// is rejected with a HeightMismatchError if it is at another height, and
+// with one of the block package errors if its header does not match its
+// contents, or if it does not follow the previously processed block.
 func (w *Wallet) CheckWireBlock(blk block.Block) (uint64, uint64, error) {
+	return w.commitBlock(w.scanBlock(blk))
+}
+
+// commitBlock verifies a scanned block, and applies it to the database. The
+// block has to be at the saved wallet height.
+func (w *Wallet) commitBlock(scan *blockScan) (uint64, uint64, error) {
+	blk := scan.blk
+
 	// Ensure this block is at the height we expect it to be
 	walletHeight, err := w.GetSavedHeight()
 	if err != nil {
@@ -141,15 +207,80 @@ func (w *Wallet) CheckWireBlock(blk block.Block) (uint64, uint64, error) {
 	}
 
 	if blk.Header.Height != walletHeight {
-		return 0, 0, fmt.Errorf("mismatch between block height and wallet height\nblock height: %v - wallet height: %v\n", blk.Header.Height, walletHeight)
+		return 0, 0, &HeightMismatchError{BlockHeight: blk.Header.Height, WalletHeight: walletHeight}
+	}
+
+	if err := w.verifyBlock(blk); err != nil {
+		return 0, 0, err
+	}
+
+	var spentCount, receivedCount uint64
+	var records []*txrecords.TxRecord
+	var recordEvents [][]Event
+	txInCheckers := NewTxInChecker(blk.Txs)
+	for i, tx := range blk.Txs {
+		spent, err := w.removeSpentOutputs(txInCheckers[i], blk.Header.Height)
+		if err != nil {
+			return 0, 0, err
+		}
+		spentCount += uint64(len(spent))
+
+		owned, received, err := w.storeOutputs(tx, scan.received[i], blk.Header.Height)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if len(owned) > 0 {
+			receivedCount++
+		}
+
+		if len(spent) == 0 && len(owned) == 0 {
+			continue
+		}
+
+		txRecord, err := w.newTxRecord(tx, blk, owned, totalAmount(spent))
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var txEvents []Event
+		for _, s := range spent {
+			e := Event{Type: EventSpent, Height: blk.Header.Height, TxID: txRecord.TxID, Amount: s.amount}
+			var pubKey [32]byte
+			copy(pubKey[:], s.pubKey)
+			e.PubKey.SetBytes(&pubKey)
+			txEvents = append(txEvents, e)
+		}
+
+		records = append(records, txRecord)
+		recordEvents = append(recordEvents, append(txEvents, received...))
+	}
+
+	confirmed, err := w.putTxRecords(blk, records)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var events []Event
+	for i, txRecord := range records {
+		events = append(events, recordEvents[i]...)
+		if confirmed[i] {
+			events = append(events, Event{
+				Type:   EventConfirmed,
+				Height: blk.Header.Height,
+				TxID:   txRecord.TxID,
+				Amount: txRecord.Amount,
+			})
+		}
 	}
 
-	spentCount, err := w.CheckWireBlockSpent(blk)
+	dropped, err := w.reconcilePending(blk)
 	if err != nil {
 		return 0, 0, err
 	}
+	events = append(events, dropped...)
 
-	receivedCount, err := w.CheckWireBlockReceived(blk)
+	err = w.db.PutHeaderHash(blk.Header.Height, blk.Header.Hash, HeaderHistory)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -164,35 +295,123 @@ func (w *Wallet) CheckWireBlock(blk block.Block) (uint64, uint64, error) {
 		return 0, 0, err
 	}
 
-	if err := w.db.UpdateLockedInputs(privSpend.Bytes(), blk.Header.Height); err != nil {
+	unlocked, err := w.db.UpdateLockedInputs(privSpend.Bytes(), blk.Header.Height)
+	if err != nil {
 		return 0, 0, err
 	}
 
+	for _, output := range unlocked {
+		events = append(events, Event{
+			Type:       EventUnlocked,
+			Height:     blk.Header.Height,
+			TxID:       output.TxID,
+			PubKey:     output.PubKey,
+			Amount:     output.Amount,
+			LockReason: output.LockReason,
+		})
+	}
+
+	w.publish(events...)
 	return spentCount, receivedCount, nil
 }
 
-func (w *Wallet) CheckUnconfirmedBalance(txs []transactions.Transaction) (uint64, error) {
-	privView, err := w.keyPair.PrivateView()
+// verifyBlock checks that the header of `blk` matches its contents, and
+// that it follows the last block processed by the wallet, if that block is
+// known.
+func (w *Wallet) verifyBlock(blk block.Block) error {
+	if err := blk.Verify(); err != nil {
+		return err
+	}
+
+	if blk.Header.Height == 0 {
+		return nil
+	}
+
+	prevHash, err := w.db.GetHeaderHash(blk.Header.Height - 1)
+	if err == leveldb.ErrNotFound {
+		// The wallet started syncing at this block
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return blk.Follows(prevHash)
+}
+
+// newTxRecord returns the record for a tx included in `blk`, without the
+// proof of its inclusion.
+func (w *Wallet) newTxRecord(tx transactions.Transaction, blk block.Block, owned map[uint32]uint64, spent uint64) (*txrecords.TxRecord, error) {
+	txRecord, err := txrecords.New(tx, blk.Header.Height, blk.Header.Timestamp, blk.Header.Hash, owned, spent)
+	if err != nil {
+		return nil, err
+	}
+
+	// Coinbase rewards stay locked until they mature
+	if tx.Type() == transactions.CoinbaseType {
+		txRecord.UnlockHeight += w.coinbaseMaturity
+	}
+
+	return txRecord, nil
+}
+
+// putTxRecords stores the records of txs included in `blk`, along with the
+// proofs of their inclusion. The merkle tree of the block is built once for
+// all of them. For each record, it returns whether it confirmed a pending
+// record.
+func (w *Wallet) putTxRecords(blk block.Block, records []*txrecords.TxRecord) ([]bool, error) {
+	txIDs := make([][]byte, len(records))
+	for i, txRecord := range records {
+		txIDs[i] = txRecord.TxID
+	}
+
+	proofs, err := blk.ProveInclusions(txIDs)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
+	confirmed := make([]bool, len(records))
+	for i, txRecord := range records {
+		txRecord.Proof = proofs[i]
+		if confirmed[i], err = w.putTxRecord(txRecord); err != nil {
+			return nil, err
+		}
+	}
+
+	return confirmed, nil
+}
+
+// putTxRecord stores the record of a tx included in a block. If the tx was
+// recorded before, while pending, that record is confirmed in place, and
+// true is returned.
+func (w *Wallet) putTxRecord(txRecord *txrecords.TxRecord) (bool, error) {
+	old, err := w.db.GetTxRecord(txRecord.TxID)
+	if err != nil && err != leveldb.ErrNotFound {
+		return false, err
+	}
+
+	var confirmed bool
+	if err == nil {
+		txRecord.PaymentID = old.PaymentID
+		txRecord.Label = old.Label
+		if len(txRecord.Counterparties) == 0 {
+			// Only the pending record of a tx built by the wallet knows
+			// the addresses it pays to
+			txRecord.Counterparties = old.Counterparties
+		}
+		confirmed = old.State == txrecords.Pending
+	}
+
+	return confirmed, w.db.PutTxRecord(txRecord)
+}
+
+// CheckUnconfirmedBalance returns the amount `txs` send to the wallet,
+// without recording them. Use CheckMempool to keep track of pending txs.
+func (w *Wallet) CheckUnconfirmedBalance(txs []transactions.Transaction) (uint64, error) {
 	var balance uint64
 	for _, tx := range txs {
-		for i, output := range tx.StandardTx().Outputs {
-			if _, ok := w.keyPair.DidReceiveTx(tx.StandardTx().R, output.PubKey, uint32(i)); !ok {
-				continue
-			}
-
-			var amount uint64
-			if transactions.ShouldEncryptValues(tx) {
-				amountScalar := transactions.DecryptAmount(output.EncryptedAmount, tx.StandardTx().R, uint32(i), *privView)
-				amount = amountScalar.BigInt().Uint64()
-			} else {
-				amount = output.EncryptedAmount.BigInt().Uint64()
-			}
-
-			balance += amount
+		for _, output := range w.scanOutputs(tx) {
+			balance += output.amount.BigInt().Uint64()
 		}
 	}
 
@@ -209,11 +428,46 @@ func (w *Wallet) Balance() (uint64, uint64, error) {
 }
 
 // FetchTxHistory will return a slice containing information about all
-// transactions made and received with this wallet.
+// transactions made and received with this wallet. Records of older wallet
+// versions make it fail with database.ErrLegacyTxRecords, until a Rescan
+// from their height replaces them.
 func (w *Wallet) FetchTxHistory() ([]txrecords.TxRecord, error) {
 	return w.db.FetchTxRecords()
 }
 
+// QueryTxHistory returns a page of the transaction history, selected and
+// ordered by `q`, along with the cursor of the next page.
+func (w *Wallet) QueryTxHistory(q txrecords.Query) ([]txrecords.TxRecord, string, error) {
+	return w.db.QueryTxRecords(q)
+}
+
+// TxRecord returns the record of the tx with id `txID`.
+func (w *Wallet) TxRecord(txID []byte) (*txrecords.TxRecord, error) {
+	return w.db.GetTxRecord(txID)
+}
+
+// SetPaymentID attaches `paymentID` to the record of the tx with id `txID`.
+func (w *Wallet) SetPaymentID(txID []byte, paymentID []byte) error {
+	if len(paymentID) > 255 {
+		return errors.New("payment id can not be longer than 255 bytes")
+	}
+
+	return w.db.UpdateTxRecord(txID, func(txRecord *txrecords.TxRecord) {
+		txRecord.PaymentID = paymentID
+	})
+}
+
+// SetLabel attaches `label` to the record of the tx with id `txID`.
+func (w *Wallet) SetLabel(txID []byte, label string) error {
+	if len(label) > math.MaxUint16 {
+		return errors.New("label is too long")
+	}
+
+	return w.db.UpdateTxRecord(txID, func(txRecord *txrecords.TxRecord) {
+		txRecord.Label = label
+	})
+}
+
 func (w *Wallet) GetSavedHeight() (uint64, error) {
 	return w.db.GetWalletHeight()
 }
@@ -247,7 +501,41 @@ func (w *Wallet) PrivateSpend() ([]byte, error) {
 	return privateSpend.Bytes(), nil
 }
 
-// ClearDatabase will remove all info from the database.
+// ClearDatabase will remove all info from the database, and reset the
+// wallet height to where a new wallet starts syncing. Use Rescan to only
+// remove what was learnt from the blocks.
 func (w *Wallet) ClearDatabase() error {
-	return w.db.Clear()
+	if err := w.db.Clear(); err != nil {
+		return err
+	}
+
+	return w.UpdateWalletHeight(w.startHeight())
+}
+
+// Rescan discards everything the wallet learnt from the blocks at or above
+// `fromHeight`, so that they are processed again. Payment ids and labels
+// attached to the affected tx records are kept. It must not be called
+// while the wallet is syncing. Syncer.Rescan processes the blocks again
+// straight away.
+func (w *Wallet) Rescan(fromHeight uint64) error {
+	height, err := w.GetSavedHeight()
+	if err != nil {
+		return err
+	}
+
+	if fromHeight > height {
+		return fmt.Errorf("can not rescan from height %d, the wallet is at height %d", fromHeight, height)
+	}
+
+	privSpend, err := w.keyPair.PrivateSpend()
+	if err != nil {
+		return err
+	}
+
+	if err := w.db.Rescan(privSpend.Bytes(), fromHeight); err != nil {
+		return err
+	}
+
+	w.publish(Event{Type: EventRollback, Height: fromHeight})
+	return nil
 }
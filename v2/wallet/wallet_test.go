@@ -2,18 +2,37 @@ package wallet
 
 import (
 	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/dusk-network/dusk-wallet/v2/block"
 	"github.com/dusk-network/dusk-wallet/v2/database"
 	"github.com/dusk-network/dusk-wallet/v2/key"
 	"github.com/dusk-network/dusk-wallet/v2/transactions"
+	"github.com/dusk-network/dusk-wallet/v2/txrecords"
 
 	"github.com/bwesterb/go-ristretto"
 	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/goleveldb/leveldb"
+	"golang.org/x/crypto/sha3"
 )
 
 const dbPath = "testDb"
@@ -46,6 +65,79 @@ func TestNewWallet(t *testing.T) {
 
 }
 
+func TestWalletBirthday(t *testing.T) {
+	netPrefix := byte(1)
+
+	db, err := database.New(dbPath)
+	assert.Nil(t, err)
+	defer os.RemoveAll(dbPath)
+	defer os.Remove(walletPath)
+
+	w, err := NewWithBirthday(rand.Read, 5000, netPrefix, db, GenerateDecoys, GenerateInputs, "pass", walletPath)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5000), w.Birthday())
+
+	height, err := w.GetSavedHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5000-BirthdayMargin), height)
+
+	// The birthday is read back from the seed file, and used by a wallet
+	// with an empty database
+	restoredPath := "restored"
+	restoredDB, err := database.New(restoredPath)
+	assert.Nil(t, err)
+	defer os.RemoveAll(restoredPath)
+
+	restored, err := LoadFromFile(netPrefix, restoredDB, GenerateDecoys, GenerateInputs, "pass", walletPath)
+	assert.NoError(t, err)
+	assert.Equal(t, w.PublicKey(), restored.PublicKey())
+	assert.Equal(t, uint64(5000), restored.Birthday())
+
+	height, err = restored.GetSavedHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5000-BirthdayMargin), height)
+
+	assert.NoError(t, restored.UpdateWalletHeight(6000))
+	assert.NoError(t, restored.ClearDatabase())
+	height, err = restored.GetSavedHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5000-BirthdayMargin), height)
+
+	// Seed files without metadata can still be read
+	seed, _, err := fetchSeed("pass", walletPath)
+	assert.NoError(t, err)
+
+	// The header is authenticated, and can not be stripped to have the
+	// birthday read as part of the seed
+	file, err := ioutil.ReadFile(walletPath)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(walletPath, file[len(seedFileMagic)+1:], 0600))
+	_, _, err = fetchSeed("pass", walletPath)
+	assert.Error(t, err)
+
+	digest := sha3.Sum256([]byte("pass"))
+	c, err := aes.NewCipher(digest[:])
+	assert.NoError(t, err)
+	gcm, err := cipher.NewGCM(c)
+	assert.NoError(t, err)
+	nonce := make([]byte, gcm.NonceSize())
+	assert.NoError(t, ioutil.WriteFile(walletPath, gcm.Seal(nonce, nonce, seed, nil), 0600))
+
+	loaded, err := LoadFromFile(netPrefix, restoredDB, GenerateDecoys, GenerateInputs, "pass", walletPath)
+	assert.NoError(t, err)
+	assert.Equal(t, w.PublicKey(), loaded.PublicKey())
+	assert.Equal(t, uint64(0), loaded.Birthday())
+
+	// Early birthdays start from the genesis block
+	os.Remove(walletPath)
+	assert.NoError(t, restoredDB.Clear())
+	early, err := RestoreFromSeed(seed, 10, netPrefix, restoredDB, GenerateDecoys, GenerateInputs, "pass", walletPath)
+	assert.NoError(t, err)
+	height, err = early.GetSavedHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), height)
+}
+
 func TestReceivedTx(t *testing.T) {
 	netPrefix := byte(1)
 	fee := int64(0)
@@ -169,30 +261,1876 @@ func TestCheckUnconfirmedBalance(t *testing.T) {
 	assert.Equal(t, uint64(int64(numTxs)*amount), balance)
 }
 
-func TestCatchEOF(t *testing.T) {
+func TestPendingTxs(t *testing.T) {
 	netPrefix := byte(1)
 
-	db, err := database.New(dbPath)
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+	aliceAddr, err := alice.keyPair.PublicKey().PublicAddress(netPrefix)
 	assert.Nil(t, err)
-	defer os.RemoveAll(dbPath)
 
-	// Generate 1000 new wallets
-	for i := 0; i < 1000; i++ {
-		_, err = New(rand.Read, netPrefix, db, GenerateDecoys, GenerateInputs, "pass", walletPath)
-		assert.Nil(t, err)
-		os.Remove(walletPath)
+	// Bob receives 5000 from a third party
+	blk := block.NewBlock()
+	blk.AddTx(generateStandardTx(t, *bobAddr, 5000, alice))
+	sealBlock(t, blk, nil)
+	for _, w := range []*Wallet{alice, bob} {
+		_, _, err = w.CheckWireBlock(*blk)
+		assert.NoError(t, err)
+	}
+
+	// Alice got change from the mock inputs
+	before, err := alice.BalanceSummary()
+	assert.NoError(t, err)
+
+	// Txs from a third party, paying Alice or Bob
+	other := generateStandardTx(t, *aliceAddr, 500, bob)
+	unrelated := generateStandardTx(t, *bobAddr, 700, bob)
+	conflict := generateStandardTx(t, *bobAddr, 300, bob)
+
+	// Bob broadcasts a payment of 1200 to Alice, with a fee of 100
+	bob.fetchInputs = fetchInputs
+	tx, err := bob.NewStandardTx(100)
+	assert.NoError(t, err)
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(1200))
+	assert.NoError(t, tx.AddOutput(*aliceAddr, amount))
+	assert.NoError(t, bob.Sign(tx))
+
+	added, err := bob.AddPendingTx(tx)
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	summary, err := bob.BalanceSummary()
+	assert.NoError(t, err)
+	assert.Equal(t, BalanceSummary{PendingOutgoing: 1300}, summary)
+
+	// Alice sees it in the mempool, along with another payment and a tx
+	// which does not concern her
+	mempool := []transactions.Transaction{tx, other, unrelated}
+	count, err := alice.CheckMempool(mempool)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	// Txs are only recorded once
+	count, err = alice.CheckMempool(mempool)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+
+	summary, err = alice.BalanceSummary()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1700), summary.PendingIncoming)
+	assert.Equal(t, uint64(0), summary.PendingOutgoing)
+
+	pending, err := alice.PendingTxs()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pending))
+	for _, record := range pending {
+		assert.Equal(t, txrecords.Pending, record.State)
+		assert.Equal(t, txrecords.In, record.Direction)
+	}
+
+	// The block includes Bob's payment, and spends an input of the other
+	// payment in another tx
+	conflict.Inputs[0].KeyImage = other.Inputs[0].KeyImage
+
+	prev := blk
+	blk = block.NewBlock()
+	blk.AddTx(tx)
+	blk.AddTx(conflict)
+	blk.Header.Seed = make([]byte, block.BLSSize)
+	sealBlock(t, blk, prev)
+
+	events, unsubscribe := alice.SubscribeChan(10)
+	_, _, err = alice.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+	unsubscribe()
+
+	var types []EventType
+	for e := range events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []EventType{EventReceived, EventConfirmed, EventDropped}, types)
+
+	summary, err = alice.BalanceSummary()
+	assert.NoError(t, err)
+	assert.Equal(t, BalanceSummary{Unlocked: before.Unlocked + 1200}, summary)
+
+	otherID, err := other.CalculateHash()
+	assert.NoError(t, err)
+	_, err = alice.TxRecord(otherID)
+	assert.Equal(t, leveldb.ErrNotFound, err)
+
+	// Bob gets the block from a node, so the addresses of the outputs are
+	// only known to his pending record
+	buf := new(bytes.Buffer)
+	assert.NoError(t, block.Marshal(buf, blk))
+	decoded, err := DecodeWireBlock(buf.Bytes())
+	assert.NoError(t, err)
+	_, _, err = bob.CheckWireBlock(*decoded)
+	assert.NoError(t, err)
+
+	summary, err = bob.BalanceSummary()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), summary.PendingOutgoing)
+
+	sent, _, err := bob.QueryTxHistory(txrecords.Query{Counterparty: aliceAddr.String()})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(sent))
+	assert.Equal(t, txrecords.Confirmed, sent[0].State)
+	assert.Equal(t, []string{aliceAddr.String()}, sent[0].Counterparties)
+
+	// Alice can not tell who paid her
+	history, err := alice.FetchTxHistory()
+	assert.NoError(t, err)
+	for _, record := range history {
+		assert.Equal(t, 0, len(record.Counterparties))
 	}
+
+	pending, err = bob.PendingTxs()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(pending))
 }
 
-func generateWallet(t *testing.T, netPrefix byte, path string, wPath string) *Wallet {
-	db, err := database.New(path)
+func TestAbandonPendingTx(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+	aliceAddr, err := alice.keyPair.PublicKey().PublicAddress(netPrefix)
 	assert.Nil(t, err)
-	defer os.RemoveAll(path)
 
-	os.Remove(wPath)
-	w, err := New(rand.Read, netPrefix, db, GenerateDecoys, GenerateInputs, "pass", wPath)
+	blk := block.NewBlock()
+	blk.AddTx(generateStandardTx(t, *bobAddr, 5000, alice))
+	sealBlock(t, blk, nil)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	// Bob broadcasts a payment, which never makes it into a block
+	bob.fetchInputs = fetchInputs
+	tx, err := bob.NewStandardTx(100)
+	assert.NoError(t, err)
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(1200))
+	assert.NoError(t, tx.AddOutput(*aliceAddr, amount))
+	assert.NoError(t, bob.Sign(tx))
+
+	added, err := bob.AddPendingTx(tx)
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	summary, err := bob.BalanceSummary()
+	assert.NoError(t, err)
+	assert.Equal(t, BalanceSummary{PendingOutgoing: 1300}, summary)
+
+	txID, err := tx.CalculateHash()
+	assert.NoError(t, err)
+	events, unsubscribe := bob.SubscribeChan(1)
+	assert.NoError(t, bob.AbandonPendingTx(txID))
+	unsubscribe()
+	assert.Equal(t, Event{Type: EventDropped, TxID: txID, Amount: 1300}, <-events)
+
+	// The funds are back, and the tx is forgotten
+	summary, err = bob.BalanceSummary()
+	assert.NoError(t, err)
+	assert.Equal(t, BalanceSummary{Unlocked: 5000}, summary)
+
+	_, err = bob.TxRecord(txID)
+	assert.Equal(t, leveldb.ErrNotFound, err)
+	assert.Equal(t, ErrNotPending, bob.AbandonPendingTx(txID))
+
+	// The output can be spent again
+	retry, err := bob.NewStandardTx(100)
+	assert.NoError(t, err)
+	assert.NoError(t, retry.AddOutput(*aliceAddr, amount))
+	assert.NoError(t, bob.Sign(retry))
+
+	// Confirmed txs can not be abandoned
+	prev := blk
+	blk = block.NewBlock()
+	blk.AddTx(retry)
+	sealBlock(t, blk, prev)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	retryID, err := retry.CalculateHash()
+	assert.NoError(t, err)
+	assert.Equal(t, ErrNotPending, bob.AbandonPendingTx(retryID))
+
+	summary, err = bob.BalanceSummary()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3700), summary.Unlocked)
+}
+
+func TestConfirmationPolicy(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
 	assert.Nil(t, err)
-	return w
+	aliceAddr, err := alice.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	bob.fetchInputs = fetchInputs
+	bob.SetConfirmationPolicy(ConfirmationPolicy{Change: 2, Received: 3})
+
+	blk := block.NewBlock()
+	tx := generateStandardTx(t, *bobAddr, 1000, alice)
+	blk.AddTx(tx)
+	sealBlock(t, blk, nil)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	txID, err := tx.CalculateHash()
+	assert.NoError(t, err)
+	confirmations, err := bob.Confirmations(txID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), confirmations)
+
+	summary, err := bob.BalanceSummary()
+	assert.NoError(t, err)
+	assert.Equal(t, BalanceSummary{Locked: 1000, Confirming: 1000}, summary)
+
+	// The output can not be spent before its third confirmation
+	payment, err := bob.NewStandardTx(100)
+	assert.NoError(t, err)
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(300))
+	assert.NoError(t, payment.AddOutput(*aliceAddr, amount))
+	assert.Error(t, bob.Sign(payment))
+
+	events, unsubscribe := bob.SubscribeChan(10)
+	for height := uint64(1); height < 3; height++ {
+		blk = sealBlock(t, block.NewBlock(), blk)
+		_, _, err = bob.CheckWireBlock(*blk)
+		assert.NoError(t, err)
+	}
+	unsubscribe()
+
+	var unlocked []Event
+	for e := range events {
+		if e.Type == EventUnlocked {
+			unlocked = append(unlocked, e)
+		}
+	}
+	assert.Equal(t, 1, len(unlocked))
+	assert.Equal(t, uint64(2), unlocked[0].Height)
+	assert.Equal(t, transactions.LockConfirmations, unlocked[0].LockReason)
+
+	confirmations, err = bob.Confirmations(txID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), confirmations)
+
+	summary, err = bob.BalanceSummary()
+	assert.NoError(t, err)
+	assert.Equal(t, BalanceSummary{Unlocked: 1000}, summary)
+
+	// The change needs two confirmations
+	payment, err = bob.NewStandardTx(100)
+	assert.NoError(t, err)
+	assert.NoError(t, payment.AddOutput(*aliceAddr, amount))
+	assert.NoError(t, bob.Sign(payment))
+
+	prev := blk
+	blk = block.NewBlock()
+	blk.AddTx(payment)
+	sealBlock(t, blk, prev)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	schedule, err := bob.UnlockSchedule()
+	assert.NoError(t, err)
+	assert.Equal(t, []UnlockEntry{{Height: 4, Amount: 600, Reason: transactions.LockConfirmations}}, schedule)
+}
+
+func TestBatchPayment(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	numPayments := 40
+	payments := make([]Payment, numPayments)
+	for i := range payments {
+		payments[i] = Payment{Address: *bobAddr, Amount: uint64(i + 1)}
+	}
+
+	txs, receipts, err := alice.NewBatchPayment(100, payments)
+	assert.NoError(t, err)
+
+	// 15 payments and a change output fit in a single tx
+	assert.Equal(t, 3, len(txs))
+	assert.Equal(t, numPayments, len(receipts))
+
+	for _, tx := range txs {
+		assert.True(t, len(tx.Outputs) <= transactions.MaxOutputs)
+	}
+
+	for i, receipt := range receipts {
+		assert.Equal(t, payments[i], receipt.Payment)
+
+		tx := txs[i/paymentsPerTx]
+		txid, err := tx.CalculateHash()
+		assert.NoError(t, err)
+		assert.Equal(t, txid, receipt.TxID)
+
+		output := tx.Outputs[receipt.Index]
+		_, ok := bob.keyPair.DidReceiveTx(tx.R, output.PubKey, output.Index)
+		assert.True(t, ok)
+	}
+}
+
+func TestReadPayments(t *testing.T) {
+	csv := "address,amount\npippo,100\npluto, 2500\n"
+	payments, err := ReadPayments(bytes.NewBufferString(csv))
+	assert.NoError(t, err)
+	assert.Equal(t, []Payment{{"pippo", 100}, {"pluto", 2500}}, payments)
+
+	_, err = ReadPayments(bytes.NewBufferString("pippo,100\npluto,abc\n"))
+	assert.Error(t, err)
+}
+
+func TestConsolidate(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	// Send Bob some small amounts, and one big one
+	blk := block.NewBlock()
+	for i := 0; i < 5; i++ {
+		blk.AddTx(generateStandardTx(t, *bobAddr, 20, alice))
+	}
+	blk.AddTx(generateStandardTx(t, *bobAddr, 5000, alice))
+	sealBlock(t, blk, nil)
+
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	// Fee budget does not allow for a single tx
+	txs, err := bob.Consolidate(100, 10, 5)
+	assert.NoError(t, err)
+	assert.Empty(t, txs)
+
+	txs, err = bob.Consolidate(100, 10, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(txs))
+	assert.Equal(t, 5, len(txs[0].Inputs))
+	assert.Equal(t, 1, len(txs[0].Outputs))
+
+	privView, err := bob.keyPair.PrivateView()
+	assert.NoError(t, err)
+	amount := transactions.DecryptAmount(txs[0].Outputs[0].EncryptedAmount, txs[0].R, 0, *privView)
+	assert.Equal(t, uint64(5*20-10), amount.BigInt().Uint64())
+
+	// Only the big input should be left
+	unlocked, _, err := bob.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5000), unlocked)
+}
+
+func TestChangePolicy(t *testing.T) {
+	// Disabled
+	assert.Equal(t, []int64{1000}, ChangePolicy{}.split(1000, 10))
+
+	p := ChangePolicy{SplitAbove: 300}
+	assert.Equal(t, []int64{250, 250, 250, 250}, p.split(1000, 10))
+	// Not enough free outputs
+	assert.Equal(t, []int64{500, 500}, p.split(1000, 2))
+
+	p.MaxOutputs = 3
+	assert.Equal(t, []int64{333, 333, 334}, p.split(1000, 10))
+
+	// Zero change still gets an output
+	assert.Equal(t, []int64{0}, p.split(0, 10))
+}
+
+func TestCoinControl(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	blk := block.NewBlock()
+	blk.AddTx(generateStandardTx(t, *bobAddr, 20, alice))
+	blk.AddTx(generateStandardTx(t, *bobAddr, 5000, alice))
+	sealBlock(t, blk, nil)
+
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	outputs, err := bob.Outputs()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(outputs))
+
+	var largest database.OwnedOutput
+	for _, output := range outputs {
+		assert.Equal(t, uint64(0), output.Height)
+		assert.False(t, output.Frozen)
+		if output.Amount == 5000 {
+			largest = output
+		}
+	}
+
+	txid, err := blk.Txs[1].CalculateHash()
+	assert.NoError(t, err)
+	assert.Equal(t, txid, largest.TxID)
+
+	// Freezing the largest output leaves too little to spend
+	assert.NoError(t, bob.Freeze(largest.PubKey))
+	bob.fetchInputs = fetchInputs
+
+	tx, err := bob.NewStandardTx(10)
+	assert.NoError(t, err)
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(1000))
+	assert.NoError(t, tx.AddOutput(*bobAddr, amount))
+	assert.Error(t, bob.Sign(tx))
+
+	// Explicitly selecting it fails too, until it is thawed
+	assert.Error(t, bob.Sign(tx, largest.PubKey))
+	assert.NoError(t, bob.Thaw(largest.PubKey))
+	assert.NoError(t, bob.Sign(tx, largest.PubKey))
+	assert.Equal(t, 1, len(tx.Inputs))
+
+	outputs, err = bob.Outputs()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(outputs))
+	assert.Equal(t, uint64(20), outputs[0].Amount)
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	netPrefix := byte(1)
+
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("bob.dat")
+	const maturity = 10
+	bob.SetCoinbaseMaturity(maturity)
+
+	var reward ristretto.Scalar
+	reward.SetBigInt(big.NewInt(1000))
+	coinbase := transactions.NewCoinbase(make([]byte, 100), make([]byte, 32), netPrefix)
+	assert.NoError(t, coinbase.AddReward(bob.PublicKey(), reward))
+
+	blk := block.NewBlock()
+	blk.AddTx(coinbase)
+	sealBlock(t, blk, nil)
+	_, _, err := bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	schedule, err := bob.UnlockSchedule()
+	assert.NoError(t, err)
+	assert.Equal(t, []UnlockEntry{{maturity, 1000, transactions.LockCoinbase}}, schedule)
+
+	for height := uint64(1); height <= maturity; height++ {
+		unlocked, locked, err := bob.Balance()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), unlocked)
+		assert.Equal(t, uint64(1000), locked)
+
+		blk = sealBlock(t, block.NewBlock(), blk)
+		_, _, err = bob.CheckWireBlock(*blk)
+		assert.NoError(t, err)
+	}
+
+	unlocked, locked, err := bob.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1000), unlocked)
+	assert.Equal(t, uint64(0), locked)
+
+	schedule, err = bob.UnlockSchedule()
+	assert.NoError(t, err)
+	assert.Empty(t, schedule)
+}
+
+func TestTimelockTx(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	// Alice spends a reward she received, so that the rest of it is
+	// recognised as her change
+	var reward ristretto.Scalar
+	reward.SetBigInt(big.NewInt(2000))
+	coinbase := transactions.NewCoinbase(make([]byte, 100), make([]byte, 32), netPrefix)
+	assert.NoError(t, coinbase.AddReward(alice.PublicKey(), reward))
+
+	prev := block.NewBlock()
+	prev.AddTx(coinbase)
+	sealBlock(t, prev, nil)
+	_, _, err = alice.CheckWireBlock(*prev)
+	assert.NoError(t, err)
+	_, _, err = bob.CheckWireBlock(*prev)
+	assert.NoError(t, err)
+	alice.fetchInputs = fetchInputs
+
+	_, err = alice.NewTimelockTx(0, transactions.MaxLockTime+1)
+	assert.Error(t, err)
+
+	tx, err := alice.NewTimelockTx(0, 1000)
+	assert.NoError(t, err)
+
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(500))
+	assert.NoError(t, tx.AddOutput(*bobAddr, amount))
+	assert.NoError(t, alice.Sign(tx))
+
+	blk := block.NewBlock()
+	blk.AddTx(tx)
+	sealBlock(t, blk, prev)
+
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+	_, _, err = alice.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	// Bob's payment is locked
+	unlocked, locked, err := bob.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), unlocked)
+	assert.Equal(t, uint64(500), locked)
+
+	schedule, err := bob.UnlockSchedule()
+	assert.NoError(t, err)
+	assert.Equal(t, []UnlockEntry{{1001, 500, transactions.LockTimelock}}, schedule)
+
+	txID, err := tx.CalculateHash()
+	assert.NoError(t, err)
+	record, err := bob.TxRecord(txID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1001), record.UnlockHeight)
+
+	// Alice's change is not
+	unlocked, locked, err = alice.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1500), unlocked)
+	assert.Equal(t, uint64(0), locked)
+
+	// Funds Alice locks up for herself are not mistaken for change
+	aliceAddr, err := alice.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.NoError(t, err)
+	tx, err = alice.NewTimelockTx(0, 100)
+	assert.NoError(t, err)
+	amount.SetBigInt(big.NewInt(400))
+	assert.NoError(t, tx.AddOutput(*aliceAddr, amount))
+	assert.NoError(t, alice.Sign(tx))
+
+	prev = blk
+	blk = block.NewBlock()
+	blk.AddTx(tx)
+	sealBlock(t, blk, prev)
+	_, _, err = alice.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	unlocked, locked, err = alice.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1100), unlocked)
+	assert.Equal(t, uint64(400), locked)
+}
+
+func TestTxRecordAmounts(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+	aliceAddr, err := alice.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	// Bob receives 5000 from a third party
+	blk := block.NewBlock()
+	blk.Header.Timestamp = 1000
+	blk.AddTx(generateStandardTx(t, *bobAddr, 5000, alice))
+	sealBlock(t, blk, nil)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	// Bob then pays 1200 to Alice, with a fee of 100
+	bob.fetchInputs = fetchInputs
+	tx, err := bob.NewStandardTx(100)
+	assert.NoError(t, err)
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(1200))
+	assert.NoError(t, tx.AddOutput(*aliceAddr, amount))
+	assert.NoError(t, bob.Sign(tx))
+
+	prev := blk
+	blk = block.NewBlock()
+	blk.Header.Timestamp = 2000
+	blk.AddTx(tx)
+	sealBlock(t, blk, prev)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	records, err := bob.FetchTxHistory()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(records))
+	for _, record := range records {
+		switch record.Height {
+		case 0:
+			assert.Equal(t, txrecords.In, record.Direction)
+			assert.Equal(t, uint64(5000), record.Amount)
+			assert.Equal(t, uint64(0), record.Fee)
+			assert.Equal(t, int64(1000), record.Timestamp)
+		case 1:
+			// The change does not count as a payment
+			assert.Equal(t, txrecords.Out, record.Direction)
+			assert.Equal(t, uint64(1300), record.Amount)
+			assert.Equal(t, uint64(100), record.Fee)
+			assert.Equal(t, int64(2000), record.Timestamp)
+			assert.Equal(t, []string{hex.EncodeToString(tx.Outputs[0].PubKey.P.Bytes())}, record.Recipients)
+		}
+	}
+
+	// Records can be looked up by txid, and keep their payment id
+	txID, err := tx.CalculateHash()
+	assert.NoError(t, err)
+	assert.NoError(t, bob.SetPaymentID(txID, []byte("invoice")))
+
+	record, err := bob.TxRecord(txID)
+	assert.NoError(t, err)
+	assert.Equal(t, txrecords.Confirmed, record.State)
+	assert.Equal(t, []byte("invoice"), record.PaymentID)
+	assert.Error(t, bob.SetPaymentID(make([]byte, 32), []byte("invoice")))
+}
+
+func TestCheckWireBlockRecords(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	defer os.Remove("alice.dat")
+	aliceAddr, err := alice.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	// The net amount is recorded whichever of the two checks comes first
+	for _, receivedFirst := range []bool{true, false} {
+		bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+		bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+		assert.Nil(t, err)
+
+		blk := block.NewBlock()
+		blk.AddTx(generateStandardTx(t, *bobAddr, 5000, alice))
+		_, err = bob.CheckWireBlockReceived(*blk)
+		assert.NoError(t, err)
+
+		// Bob pays 1200 to Alice, with a fee of 100
+		bob.fetchInputs = fetchInputs
+		tx, err := bob.NewStandardTx(100)
+		assert.NoError(t, err)
+		var amount ristretto.Scalar
+		amount.SetBigInt(big.NewInt(1200))
+		assert.NoError(t, tx.AddOutput(*aliceAddr, amount))
+		assert.NoError(t, bob.Sign(tx))
+
+		blk = block.NewBlock()
+		blk.Header.Height = 1
+		blk.AddTx(tx)
+		if receivedFirst {
+			_, err = bob.CheckWireBlockReceived(*blk)
+			assert.NoError(t, err)
+		}
+
+		count, err := bob.CheckWireBlockSpent(*blk)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), count)
+
+		if !receivedFirst {
+			_, err = bob.CheckWireBlockReceived(*blk)
+			assert.NoError(t, err)
+		}
+
+		records, err := bob.FetchTxHistory()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(records))
+		for _, record := range records {
+			assert.NotNil(t, record.Proof)
+			switch record.Height {
+			case 0:
+				assert.Equal(t, txrecords.In, record.Direction)
+				assert.Equal(t, uint64(5000), record.Amount)
+			case 1:
+				assert.Equal(t, txrecords.Out, record.Direction)
+				assert.Equal(t, uint64(1300), record.Amount)
+			}
+		}
+
+		os.Remove("bob.dat")
+	}
+}
+
+func TestExportTxHistory(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	// Bob receives a payment in each of three blocks, a day apart
+	var txIDs [][]byte
+	var blk *block.Block
+	for i := 0; i < 3; i++ {
+		tx := generateStandardTx(t, *bobAddr, int64(DUSK)+int64(i), alice)
+		txID, err := tx.CalculateHash()
+		assert.NoError(t, err)
+		txIDs = append(txIDs, txID)
+
+		prev := blk
+		blk = block.NewBlock()
+		blk.Header.Timestamp = int64(i * 86400)
+		blk.AddTx(tx)
+		sealBlock(t, blk, prev)
+		_, _, err = bob.CheckWireBlock(*blk)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, bob.SetLabel(txIDs[1], "salary, march"))
+	assert.NoError(t, bob.SetPaymentID(txIDs[1], []byte{0xca, 0xfe}))
+
+	// A payment is still pending
+	pendingID := []byte{1, 2, 3}
+	assert.NoError(t, bob.db.PutTxRecord(&txrecords.TxRecord{
+		TxID:      pendingID,
+		State:     txrecords.Pending,
+		Timestamp: 3 * 86400,
+		TxType:    transactions.StandardType,
+		Amount:    500,
+	}))
+
+	// Only the last two days
+	buf := new(bytes.Buffer)
+	assert.NoError(t, bob.ExportTxHistory(buf, CSV, 86400, 0))
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(rows))
+	assert.Equal(t, csvHeader, rows[0])
+	assert.Equal(t, []string{
+		hex.EncodeToString(txIDs[1]), "confirmed", "1", "1970-01-02T00:00:00Z", "in", "standard",
+		"100000001", "1.00000001", "0", "0.00000000", "cafe", "salary, march",
+	}, rows[1])
+	assert.Equal(t, []string{
+		hex.EncodeToString(pendingID), "pending", "0", "1970-01-04T00:00:00Z", "in", "standard",
+		"500", "0.00000500", "0", "0.00000000", "", "",
+	}, rows[3])
+
+	// Only the first day
+	buf.Reset()
+	assert.NoError(t, bob.ExportTxHistory(buf, JSONLines, 0, 86399))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 1, len(lines))
+
+	var record exportedRecord
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, hex.EncodeToString(txIDs[0]), record.TxID)
+	assert.Equal(t, "1.00000000", record.AmountDusk)
+	assert.Equal(t, DUSK, record.Amount)
+}
+
+// flakySource fails every other request.
+type flakySource struct {
+	BlockSource
+	lock  sync.Mutex
+	calls int
+}
+
+func (f *flakySource) BlockAtHeight(ctx context.Context, height uint64) (*block.Block, error) {
+	f.lock.Lock()
+	f.calls++
+	fail := f.calls%2 == 1
+	f.lock.Unlock()
+
+	if fail {
+		return nil, TransientError{errors.New("connection reset")}
+	}
+
+	return f.BlockSource.BlockAtHeight(ctx, height)
+}
+
+func TestSyncer(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	source := NewMemorySource()
+	var blk *block.Block
+	for i := 0; i < 5; i++ {
+		prev := blk
+		blk = block.NewBlock()
+		blk.AddTx(generateStandardTx(t, *bobAddr, 100, alice))
+		source.Add(sealBlock(t, blk, prev))
+	}
+
+	// A cancelled sync does not process anything
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, NewSyncer(bob, source, nil).Sync(ctx))
+
+	height, err := bob.GetSavedHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), height)
+
+	// Transient errors are retried
+	var progress []SyncProgress
+	syncer := NewSyncer(bob, &flakySource{BlockSource: source}, func(p SyncProgress) {
+		progress = append(progress, p)
+	})
+	syncer.RetryDelay = time.Millisecond
+	assert.NoError(t, syncer.Sync(context.Background()))
+
+	assert.Equal(t, 5, len(progress))
+	assert.Equal(t, SyncProgress{Height: 4, Tip: 4, Received: 1}, progress[4])
+
+	unlocked, _, err := bob.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(500), unlocked)
+
+	// Unless retries are disabled
+	source.Add(sealBlock(t, block.NewBlock(), blk))
+	syncer = NewSyncer(bob, &flakySource{BlockSource: source}, nil)
+	syncer.MaxRetries = 0
+	assert.Error(t, syncer.Sync(context.Background()))
+}
+
+// slowSource answers block requests for lower heights later, so that the
+// workers of a Syncer finish their blocks out of order.
+type slowSource struct {
+	BlockSource
+	tip uint64
+
+	lock              sync.Mutex
+	inFlight, maxSeen int
+}
+
+func (s *slowSource) BlockAtHeight(ctx context.Context, height uint64) (*block.Block, error) {
+	s.lock.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxSeen {
+		s.maxSeen = s.inFlight
+	}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		s.inFlight--
+		s.lock.Unlock()
+	}()
+
+	select {
+	case <-time.After(time.Duration(s.tip-height) * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return s.BlockSource.BlockAtHeight(ctx, height)
+}
+
+func TestSyncerOrder(t *testing.T) {
+	netPrefix := byte(1)
+
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("bob.dat")
+
+	const blocks = 16
+	source := NewMemorySource()
+	var blk *block.Block
+	for i := 0; i < blocks; i++ {
+		prev := blk
+		blk = block.NewBlock()
+		source.Add(sealBlock(t, blk, prev))
+	}
+
+	// Blocks fetched concurrently, and finished in reverse, are still
+	// committed in height order
+	var heights []uint64
+	slow := &slowSource{BlockSource: source, tip: blocks - 1}
+	syncer := NewSyncer(bob, slow, func(p SyncProgress) {
+		heights = append(heights, p.Height)
+	})
+	syncer.Workers = 4
+	assert.NoError(t, syncer.Sync(context.Background()))
+
+	assert.Equal(t, blocks, len(heights))
+	for i, height := range heights {
+		assert.Equal(t, uint64(i), height)
+	}
+
+	assert.True(t, slow.maxSeen > 1)
+	assert.True(t, slow.maxSeen <= syncer.Workers)
+
+	height, err := bob.GetSavedHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(blocks), height)
+}
+
+// failingSource fails every block request with `err`, after calling
+// `before`, if set.
+type failingSource struct {
+	BlockSource
+	err    error
+	before func()
+	calls  int
+}
+
+func (f *failingSource) BlockAtHeight(ctx context.Context, height uint64) (*block.Block, error) {
+	f.calls++
+	if f.before != nil {
+		f.before()
+	}
+
+	return nil, f.err
+}
+
+func TestSyncerRetry(t *testing.T) {
+	netPrefix := byte(1)
+
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("bob.dat")
+
+	source := NewMemorySource()
+	source.Add(sealBlock(t, block.NewBlock(), nil))
+
+	// Missing blocks are not retried
+	failing := &failingSource{BlockSource: source, err: ErrBlockNotFound}
+	syncer := NewSyncer(bob, failing, nil)
+	syncer.RetryDelay = time.Millisecond
+	syncer.Workers = 1
+	assert.Equal(t, ErrBlockNotFound, syncer.Sync(context.Background()))
+	assert.Equal(t, 1, failing.calls)
+
+	// Neither are requests failing because the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failing = &failingSource{BlockSource: source, err: errors.New("request cancelled"), before: cancel}
+	syncer = NewSyncer(bob, failing, nil)
+	syncer.RetryDelay = time.Hour
+	syncer.Workers = 1
+	assert.Equal(t, context.Canceled, syncer.Sync(ctx))
+	assert.Equal(t, 1, failing.calls)
+
+	// Nor are blocks which can not be decoded
+	failing = &failingSource{BlockSource: source, err: io.ErrUnexpectedEOF}
+	syncer = NewSyncer(bob, failing, nil)
+	syncer.RetryDelay = time.Millisecond
+	syncer.Workers = 1
+	assert.Equal(t, io.ErrUnexpectedEOF, syncer.Sync(context.Background()))
+	assert.Equal(t, 1, failing.calls)
+
+	// Transient errors are retried until the retries run out
+	failing = &failingSource{BlockSource: source, err: TransientError{errors.New("connection reset")}}
+	syncer = NewSyncer(bob, failing, nil)
+	syncer.RetryDelay = time.Millisecond
+	syncer.Workers = 1
+	syncer.MaxRetries = 3
+	assert.Equal(t, failing.err, syncer.Sync(context.Background()))
+	assert.Equal(t, 4, failing.calls)
+
+	height, err := bob.GetSavedHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), height)
+}
+
+func TestBlockValidation(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	genesis := block.NewBlock()
+	genesis.AddTx(generateStandardTx(t, *bobAddr, 100, alice))
+	sealBlock(t, genesis, nil)
+
+	// Tampering with the header invalidates the hash
+	blk := *genesis
+	header := *genesis.Header
+	header.Timestamp++
+	blk.Header = &header
+	_, _, err = bob.CheckWireBlock(blk)
+	assert.Equal(t, block.ErrInvalidHash, err)
+
+	// Tampering with the txs invalidates the tx root
+	blk = *genesis
+	blk.Txs = append(blk.Txs, generateStandardTx(t, *bobAddr, 100, alice))
+	_, _, err = bob.CheckWireBlock(blk)
+	assert.Equal(t, block.ErrInvalidTxRoot, err)
+
+	_, _, err = bob.CheckWireBlock(*genesis)
+	assert.NoError(t, err)
+
+	// The next block has to be at the next height, and link to the genesis
+	next := sealBlock(t, block.NewBlock(), genesis)
+	next.Header.Height = 2
+	sealBlock(t, next, nil)
+	_, _, err = bob.CheckWireBlock(*next)
+	assert.Equal(t, &HeightMismatchError{BlockHeight: 2, WalletHeight: 1}, err)
+
+	next = block.NewBlock()
+	next.Header.Height = 1
+	next.Header.PrevBlockHash = make([]byte, 32)
+	sealBlock(t, next, nil)
+	_, _, err = bob.CheckWireBlock(*next)
+	assert.Equal(t, block.ErrPrevBlockMismatch, err)
+
+	_, _, err = bob.CheckWireBlock(*sealBlock(t, block.NewBlock(), genesis))
+	assert.NoError(t, err)
+}
+
+func TestTxRecordProof(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	aliceAddr, err := alice.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	// Bob receives the third and the last of five txs
+	blk := block.NewBlock()
+	for i := 0; i < 5; i++ {
+		receiver := *aliceAddr
+		if i == 2 || i == 4 {
+			receiver = *bobAddr
+		}
+		blk.AddTx(generateStandardTx(t, receiver, 100, alice))
+	}
+	sealBlock(t, blk, nil)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	records, err := bob.FetchTxHistory()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(records))
+
+	for _, record := range records {
+		assert.NotNil(t, record.Proof)
+		assert.NoError(t, record.Proof.Verify(record.TxID, blk.Header.TxRoot))
+
+		// The proof does not hold for other txs, or other roots
+		otherID, err := blk.Txs[0].CalculateHash()
+		assert.NoError(t, err)
+		assert.Equal(t, block.ErrInvalidProof, record.Proof.Verify(otherID, blk.Header.TxRoot))
+		assert.Equal(t, block.ErrInvalidProof, record.Proof.Verify(record.TxID, otherID))
+	}
+}
+
+func TestSyncerFork(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	// Bob receives 100 in each of five blocks
+	source := NewMemorySource()
+	var prev, forkPoint *block.Block
+	for i := 0; i < 5; i++ {
+		blk := block.NewBlock()
+		blk.AddTx(generateStandardTx(t, *bobAddr, 100, alice))
+		source.Add(sealBlock(t, blk, prev))
+		prev = blk
+		if i == 2 {
+			forkPoint = blk
+		}
+	}
+	assert.NoError(t, NewSyncer(bob, source, nil).Sync(context.Background()))
+
+	// The chain forks after the third block, and grows to six blocks, each
+	// paying 200
+	prev = forkPoint
+	for i := 3; i < 6; i++ {
+		blk := block.NewBlock()
+		blk.Header.Timestamp = 1
+		blk.AddTx(generateStandardTx(t, *bobAddr, 200, alice))
+		source.Add(sealBlock(t, blk, prev))
+		prev = blk
+	}
+
+	events, unsubscribe := bob.SubscribeChan(20)
+	assert.NoError(t, NewSyncer(bob, source, nil).Sync(context.Background()))
+	unsubscribe()
+	assert.Equal(t, Event{Type: EventRollback, Height: 3}, <-events)
+
+	unlocked, _, err := bob.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(300+600), unlocked)
+
+	height, err := bob.GetSavedHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(6), height)
+
+	// A source on an unrelated chain is rejected
+	other := NewMemorySource()
+	prev = nil
+	for i := 0; i < 7; i++ {
+		blk := block.NewBlock()
+		blk.Header.Timestamp = 2
+		other.Add(sealBlock(t, blk, prev))
+		prev = blk
+	}
+	assert.Equal(t, ErrForkTooDeep, NewSyncer(bob, other, nil).Sync(context.Background()))
+}
+
+func TestRescan(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+	aliceAddr, err := alice.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	// Bob receives 5000, and then pays 1200 to Alice with a fee of 100
+	source := NewMemorySource()
+	blk := block.NewBlock()
+	blk.AddTx(generateStandardTx(t, *bobAddr, 5000, alice))
+	source.Add(sealBlock(t, blk, nil))
+	assert.NoError(t, NewSyncer(bob, source, nil).Sync(context.Background()))
+
+	bob.fetchInputs = fetchInputs
+	tx, err := bob.NewStandardTx(100)
+	assert.NoError(t, err)
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(1200))
+	assert.NoError(t, tx.AddOutput(*aliceAddr, amount))
+	assert.NoError(t, bob.Sign(tx))
+
+	prev := blk
+	blk = block.NewBlock()
+	blk.AddTx(tx)
+	source.Add(sealBlock(t, blk, prev))
+	assert.NoError(t, NewSyncer(bob, source, nil).Sync(context.Background()))
+
+	txID, err := tx.CalculateHash()
+	assert.NoError(t, err)
+	assert.NoError(t, bob.SetLabel(txID, "coffee"))
+
+	// Rescanning the last block restores the spent output
+	assert.Error(t, bob.Rescan(3))
+	events, unsubscribe := bob.SubscribeChan(1)
+	assert.NoError(t, bob.Rescan(1))
+	unsubscribe()
+	assert.Equal(t, Event{Type: EventRollback, Height: 1}, <-events)
+
+	unlocked, _, err := bob.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5000), unlocked)
+
+	_, err = bob.TxRecord(txID)
+	assert.Equal(t, leveldb.ErrNotFound, err)
+
+	// Syncing again brings the wallet back to where it was, with the label
+	var progress []SyncProgress
+	syncer := NewSyncer(bob, source, func(p SyncProgress) {
+		progress = append(progress, p)
+	})
+	assert.NoError(t, syncer.Rescan(context.Background(), 0))
+	assert.Equal(t, 2, len(progress))
+
+	unlocked, _, err = bob.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3700), unlocked)
+
+	record, err := bob.TxRecord(txID)
+	assert.NoError(t, err)
+	assert.Equal(t, txrecords.Confirmed, record.State)
+	assert.Equal(t, txrecords.Out, record.Direction)
+	assert.Equal(t, uint64(1300), record.Amount)
+	assert.Equal(t, "coffee", record.Label)
+
+	records, err := bob.FetchTxHistory()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(records))
+
+	// Clearing the database starts over from the genesis block, and loses
+	// the label
+	assert.NoError(t, bob.ClearDatabase())
+	assert.NoError(t, syncer.Sync(context.Background()))
+	unlocked, _, err = bob.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3700), unlocked)
+
+	record, err = bob.TxRecord(txID)
+	assert.NoError(t, err)
+	assert.Equal(t, "", record.Label)
+}
+
+func TestFileSource(t *testing.T) {
+	// Blocks are encoded as their height, followed by their hash
+	decode := func(b []byte) (*block.Block, error) {
+		blk := block.NewBlock()
+		blk.Header.Height = binary.LittleEndian.Uint64(b)
+		blk.Header.Hash = b[8:]
+		return blk, nil
+	}
+
+	f, err := os.Create("blocks.dat")
+	assert.NoError(t, err)
+	defer os.Remove("blocks.dat")
+
+	for i := 0; i < 10; i++ {
+		b := make([]byte, 8+32)
+		binary.LittleEndian.PutUint64(b, uint64(i))
+		b[8] = byte(i)
+		assert.NoError(t, WriteBlockFrame(f, b))
+	}
+	assert.NoError(t, f.Close())
+
+	source, err := NewFileSource("blocks.dat", decode)
+	assert.NoError(t, err)
+	defer source.Close()
+
+	tip, err := source.TipHeight(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(9), tip)
+
+	blk, err := source.BlockAtHeight(context.Background(), 7)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(7), blk.Header.Hash[0])
+
+	hash := make([]byte, 32)
+	hash[0] = 3
+	blk, err = source.BlockByHash(context.Background(), hash)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), blk.Header.Height)
+
+	_, err = source.BlockAtHeight(context.Background(), 10)
+	assert.Equal(t, ErrBlockNotFound, err)
+}
+
+func TestWireBlockFileSource(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.NoError(t, err)
+	bob.SetCoinbaseMaturity(60)
+
+	var reward ristretto.Scalar
+	reward.SetBigInt(big.NewInt(1000))
+	coinbase := transactions.NewCoinbase(make([]byte, 100), make([]byte, 32), netPrefix)
+	assert.NoError(t, coinbase.AddReward(bob.PublicKey(), reward))
+
+	timelock, err := alice.NewTimelockTx(0, 2)
+	assert.NoError(t, err)
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(300))
+	assert.NoError(t, timelock.AddOutput(*bobAddr, amount))
+	assert.NoError(t, alice.Sign(timelock))
+
+	// The previous block hash is a fixed size field, so the genesis block
+	// links to an empty hash
+	blk := block.NewBlock()
+	blk.Header.PrevBlockHash = make([]byte, block.HeaderHashSize)
+	blk.Header.Seed = make([]byte, block.BLSSize)
+	blk.AddTx(coinbase)
+	blk.AddTx(generateStandardTx(t, *bobAddr, 500, alice))
+	blk.AddTx(timelock)
+	blk.AddTx(generateStakeTx(t, 200, alice, 10))
+	blocks := []*block.Block{sealBlock(t, blk, nil)}
+
+	blk = block.NewBlock()
+	blk.Header.Seed = make([]byte, block.BLSSize)
+	blk.AddTx(generateStandardTx(t, *bobAddr, 100, alice))
+	blocks = append(blocks, sealBlock(t, blk, blocks[0]))
+
+	f, err := os.Create("blocks.dat")
+	assert.NoError(t, err)
+	defer os.Remove("blocks.dat")
+
+	for _, blk := range blocks {
+		buf := new(bytes.Buffer)
+		assert.NoError(t, block.Marshal(buf, blk))
+		assert.NoError(t, WriteBlockFrame(f, buf.Bytes()))
+	}
+	assert.NoError(t, f.Close())
+
+	source, err := NewFileSource("blocks.dat", DecodeWireBlock)
+	assert.NoError(t, err)
+	defer source.Close()
+
+	assert.NoError(t, NewSyncer(bob, source, nil).Sync(context.Background()))
+	unlocked, locked, err := bob.Balance()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(600), unlocked)
+	assert.Equal(t, uint64(1300), locked)
+}
+
+func TestCatchEOF(t *testing.T) {
+	netPrefix := byte(1)
+
+	db, err := database.New(dbPath)
+	assert.Nil(t, err)
+	defer os.RemoveAll(dbPath)
+
+	// Generate 1000 new wallets
+	for i := 0; i < 1000; i++ {
+		_, err = New(rand.Read, netPrefix, db, GenerateDecoys, GenerateInputs, "pass", walletPath)
+		assert.Nil(t, err)
+		os.Remove(walletPath)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	var events []Event
+	unsubscribe := bob.Subscribe(func(e Event) {
+		events = append(events, e)
+	})
+
+	c, unsubscribeChan := bob.SubscribeChan(100)
+
+	// Bob receives a payment, and a payment locked for two blocks
+	timelock, err := alice.NewTimelockTx(0, 2)
+	assert.NoError(t, err)
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(300))
+	assert.NoError(t, timelock.AddOutput(*bobAddr, amount))
+	assert.NoError(t, alice.Sign(timelock))
+
+	source := NewMemorySource()
+	blk := block.NewBlock()
+	blk.AddTx(generateStandardTx(t, *bobAddr, 1000, alice))
+	blk.AddTx(timelock)
+	source.Add(sealBlock(t, blk, nil))
+
+	for i := 1; i < 3; i++ {
+		blk = sealBlock(t, block.NewBlock(), blk)
+		source.Add(blk)
+	}
+
+	assert.NoError(t, NewSyncer(bob, source, nil).Sync(context.Background()))
+
+	types := func() []EventType {
+		var types []EventType
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+		return types
+	}
+
+	assert.Equal(t, []EventType{
+		EventReceived, EventReceived, EventSyncProgress,
+		EventSyncProgress,
+		EventUnlocked, EventSyncProgress,
+	}, types())
+	assert.Equal(t, uint64(1000), events[0].Amount)
+	assert.Equal(t, transactions.LockNone, events[0].LockReason)
+	assert.Equal(t, uint64(300), events[1].Amount)
+	assert.Equal(t, transactions.LockTimelock, events[1].LockReason)
+	assert.Equal(t, events[1].PubKey, events[4].PubKey)
+	assert.Equal(t, uint64(2), events[4].Height)
+	assert.Equal(t, SyncProgress{Height: 2, Tip: 2}, events[5].Progress)
+
+	// The channel got the same events
+	unsubscribeChan()
+	var fromChan []Event
+	for e := range c {
+		fromChan = append(fromChan, e)
+	}
+	assert.Equal(t, events, fromChan)
+
+	// Bob spends the payment, and records the tx as pending
+	bob.fetchInputs = fetchInputs
+	tx, err := bob.NewStandardTx(0)
+	assert.NoError(t, err)
+	amount.SetBigInt(big.NewInt(100))
+	assert.NoError(t, tx.AddOutput(*bobAddr, amount))
+	assert.NoError(t, bob.Sign(tx, events[0].PubKey))
+
+	txID, err := tx.CalculateHash()
+	assert.NoError(t, err)
+	assert.NoError(t, bob.db.PutTxRecord(&txrecords.TxRecord{TxID: txID, State: txrecords.Pending}))
+
+	events = nil
+	prev := blk
+	blk = block.NewBlock()
+	blk.AddTx(tx)
+	sealBlock(t, blk, prev)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []EventType{EventSpent, EventReceived, EventReceived, EventConfirmed}, types())
+	assert.Equal(t, events[0].PubKey, fromChan[0].PubKey)
+	assert.Equal(t, uint64(1000), events[0].Amount)
+	assert.Equal(t, txID, events[3].TxID)
+
+	// Unsubscribed callbacks are not called anymore
+	unsubscribe()
+	events = nil
+	prev = blk
+	blk = block.NewBlock()
+	blk.AddTx(generateStandardTx(t, *bobAddr, 1000, alice))
+	sealBlock(t, blk, prev)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(events))
+}
+
+func TestNotifier(t *testing.T) {
+	netPrefix := byte(1)
+
+	alice := generateWallet(t, netPrefix, "alice", "alice.dat")
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("alice.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	secret := []byte("secret")
+	var received []Notification
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, SignWebhookBody(secret, body), r.Header.Get(SignatureHeader))
+
+		// The first delivery fails
+		if fail {
+			fail = false
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var n Notification
+		assert.NoError(t, json.Unmarshal(body, &n))
+		assert.Equal(t, n.ID, r.Header.Get(EventIDHeader))
+		received = append(received, n)
+	}))
+	defer server.Close()
+
+	_, err = NewNotifier(bob, NotifierConfig{Endpoints: []string{"http://example.com/hook"}, Secret: secret})
+	assert.Error(t, err)
+
+	notifier, err := NewNotifier(bob, NotifierConfig{
+		Endpoints:     []string{server.URL},
+		Secret:        secret,
+		Confirmations: 2,
+		RetryDelay:    time.Minute,
+	})
+	assert.NoError(t, err)
+
+	now := time.Now()
+	notifier.now = func() time.Time { return now }
+	var events []Event
+	unsubscribe := bob.Subscribe(func(e Event) {
+		events = append(events, e)
+		notifier.handle(e)
+	})
+	defer unsubscribe()
+
+	blk := block.NewBlock()
+	blk.AddTx(generateStandardTx(t, *bobAddr, 1000, alice))
+	sealBlock(t, blk, nil)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	// The first attempt fails, and is retried after the delay
+	assert.NoError(t, notifier.Flush(context.Background()))
+	assert.Equal(t, 0, len(received))
+	assert.NoError(t, notifier.Flush(context.Background()))
+	assert.Equal(t, 0, len(received))
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, notifier.Flush(context.Background()))
+	assert.Equal(t, 1, len(received))
+	assert.Equal(t, WebhookPaymentReceived, received[0].Type)
+	assert.Equal(t, uint64(1000), received[0].Amount)
+
+	// The same event is not queued twice
+	for _, e := range events {
+		notifier.handle(e)
+	}
+	assert.NoError(t, notifier.Flush(context.Background()))
+	assert.Equal(t, 1, len(received))
+
+	// The confirmation is due once the block is two blocks deep
+	blk = sealBlock(t, block.NewBlock(), blk)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+	assert.NoError(t, notifier.Flush(context.Background()))
+	assert.Equal(t, 2, len(received))
+	assert.Equal(t, WebhookPaymentConfirmed, received[1].Type)
+	assert.Equal(t, received[0].TxID, received[1].TxID)
+	assert.NotEqual(t, received[0].ID, received[1].ID)
+
+	// Delivered notifications leave the outbox
+	entries, err := bob.db.FetchOutboxEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	// A payment which is rolled back before it is confirmed is not
+	// reported as confirmed
+	prev := blk
+	blk = block.NewBlock()
+	blk.AddTx(generateStandardTx(t, *bobAddr, 500, alice))
+	sealBlock(t, blk, prev)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+	assert.NoError(t, notifier.Flush(context.Background()))
+	assert.Equal(t, 3, len(received))
+	assert.Equal(t, WebhookPaymentReceived, received[2].Type)
+
+	assert.NoError(t, bob.Rescan(blk.Header.Height))
+	for i := 0; i < 3; i++ {
+		prev = sealBlock(t, block.NewBlock(), prev)
+		_, _, err = bob.CheckWireBlock(*prev)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, notifier.Flush(context.Background()))
+	assert.Equal(t, 3, len(received))
+
+	entries, err = bob.db.FetchOutboxEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+
+	// The change of a payment of Bob is not reported as a payment
+	aliceAddr, err := alice.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.NoError(t, err)
+
+	bob.fetchInputs = fetchInputs
+	tx, err := bob.NewStandardTx(0)
+	assert.NoError(t, err)
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(100))
+	assert.NoError(t, tx.AddOutput(*aliceAddr, amount))
+	assert.NoError(t, bob.Sign(tx))
+
+	events = nil
+	blk = block.NewBlock()
+	blk.AddTx(tx)
+	sealBlock(t, blk, prev)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+
+	assert.Equal(t, EventReceived, events[1].Type)
+	assert.True(t, events[1].Change)
+	for i := 0; i < 2; i++ {
+		blk = sealBlock(t, block.NewBlock(), blk)
+		_, _, err = bob.CheckWireBlock(*blk)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, notifier.Flush(context.Background()))
+	assert.Equal(t, 3, len(received))
+
+	// A payment which a reorg includes again at another height is
+	// reported once, and confirmed at the new height
+	payment := generateStandardTx(t, *bobAddr, 700, alice)
+	fork := blk
+	blk = block.NewBlock()
+	blk.AddTx(payment)
+	sealBlock(t, blk, fork)
+	_, _, err = bob.CheckWireBlock(*blk)
+	assert.NoError(t, err)
+	assert.NoError(t, notifier.Flush(context.Background()))
+	assert.Equal(t, 4, len(received))
+
+	assert.NoError(t, bob.Rescan(blk.Header.Height))
+	prev = sealBlock(t, block.NewBlock(), fork)
+	_, _, err = bob.CheckWireBlock(*prev)
+	assert.NoError(t, err)
+
+	blk = block.NewBlock()
+	blk.AddTx(payment)
+	sealBlock(t, blk, prev)
+	for i := 0; i < 3; i++ {
+		_, _, err = bob.CheckWireBlock(*blk)
+		assert.NoError(t, err)
+		assert.NoError(t, notifier.Flush(context.Background()))
+		blk = sealBlock(t, block.NewBlock(), blk)
+	}
+
+	assert.Equal(t, 5, len(received))
+	assert.Equal(t, WebhookPaymentConfirmed, received[4].Type)
+	assert.Equal(t, received[3].TxID, received[4].TxID)
+	assert.Equal(t, prev.Header.Height+1, received[4].Height)
+}
+
+func TestViewTags(t *testing.T) {
+	netPrefix := byte(1)
+
+	bob := generateWallet(t, netPrefix, "bob", "bob.dat")
+	defer os.Remove("bob.dat")
+	bobAddr, err := bob.keyPair.PublicKey().PublicAddress(netPrefix)
+	assert.Nil(t, err)
+
+	tx, err := transactions.NewStandard(transactions.ViewTagVersion, netPrefix, 0)
+	assert.NoError(t, err)
+
+	var amount ristretto.Scalar
+	amount.SetBigInt(big.NewInt(100))
+	assert.NoError(t, tx.AddOutput(*bobAddr, amount))
+	assert.NoError(t, tx.AddOutput(*bobAddr, amount))
+
+	assert.Equal(t, 2, len(bob.scanOutputs(tx)))
+
+	// An output with the wrong view tag is skipped
+	tx.Outputs[1].ViewTag++
+	received := bob.scanOutputs(tx)
+	assert.Equal(t, 1, len(received))
+	assert.Equal(t, uint32(0), received[0].index)
+
+	// Unless the tx predates view tags
+	tx.Version = 0
+	assert.Equal(t, 2, len(bob.scanOutputs(tx)))
+}
+
+// BenchmarkScanBlock compares scanning a block of 100 txs with 16 outputs
+// each, one of which belongs to the wallet, when deriving the shared secret
+// for every output, once per tx, and once per tx with view tags.
+func BenchmarkScanBlock(b *testing.B) {
+	netPrefix := byte(1)
+
+	db, err := database.New(dbPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dbPath)
+	defer os.Remove(walletPath)
+
+	w, err := New(rand.Read, netPrefix, db, GenerateDecoys, GenerateInputs, "pass", walletPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	addr, err := w.keyPair.PublicKey().PublicAddress(netPrefix)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	blk := block.NewBlock()
+	for i := 0; i < 100; i++ {
+		tx, err := transactions.NewStandard(0, netPrefix, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for j := 0; j < transactions.MaxOutputs; j++ {
+			var amount ristretto.Scalar
+			amount.SetBigInt(big.NewInt(100))
+
+			to := addr
+			if j > 0 {
+				to, err = key.NewKeyPair([]byte{byte(i), byte(j)}).PublicKey().PublicAddress(netPrefix)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			if err := tx.AddOutput(*to, amount); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		blk.AddTx(tx)
+	}
+
+	privView, err := w.keyPair.PrivateView()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("per output", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, tx := range blk.Txs {
+				R := tx.StandardTx().R
+				for i, output := range tx.StandardTx().Outputs {
+					if _, ok := w.keyPair.DidReceiveTx(R, output.PubKey, uint32(i)); !ok {
+						continue
+					}
+
+					transactions.DecryptAmount(output.EncryptedAmount, R, uint32(i), *privView)
+					transactions.DecryptMask(output.EncryptedMask, R, uint32(i), *privView)
+				}
+			}
+		}
+	})
+
+	b.Run("per tx", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			w.scanBlock(*blk)
+		}
+	})
+
+	for _, tx := range blk.Txs {
+		tx.StandardTx().Version = transactions.ViewTagVersion
+	}
+
+	b.Run("view tags", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			w.scanBlock(*blk)
+		}
+	})
+}
+
+func BenchmarkSync(b *testing.B) {
+	netPrefix := byte(1)
+
+	db, err := database.New(dbPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dbPath)
+	defer os.Remove(walletPath)
+
+	w, err := New(rand.Read, netPrefix, db, GenerateDecoys, GenerateInputs, "pass", walletPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	addr, err := w.keyPair.PublicKey().PublicAddress(netPrefix)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// 50 blocks of 20 txs, each paying the wallet once
+	source := NewMemorySource()
+	var prev *block.Block
+	for height := 0; height < 50; height++ {
+		blk := block.NewBlock()
+		for i := 0; i < 20; i++ {
+			tx, err := transactions.NewStandard(0, netPrefix, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var amount ristretto.Scalar
+			amount.SetBigInt(big.NewInt(100))
+			if err := tx.AddOutput(*addr, amount); err != nil {
+				b.Fatal(err)
+			}
+
+			blk.AddTx(tx)
+		}
+
+		if prev != nil {
+			blk.Header.Height = prev.Header.Height + 1
+			blk.SetPrevBlock(prev.Header)
+		}
+
+		if blk.Header.TxRoot, err = blk.CalculateRoot(); err != nil {
+			b.Fatal(err)
+		}
+
+		if blk.Header.Hash, err = blk.CalculateHash(); err != nil {
+			b.Fatal(err)
+		}
+
+		source.Add(blk)
+		prev = blk
+	}
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("%d workers", workers), func(b *testing.B) {
+			syncer := NewSyncer(w, source, nil)
+			syncer.Workers = workers
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				if err := w.Rescan(0); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if err := syncer.Sync(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func generateWallet(t *testing.T, netPrefix byte, path string, wPath string) *Wallet {
+	db, err := database.New(path)
+	assert.Nil(t, err)
+	defer os.RemoveAll(path)
+
+	os.Remove(wPath)
+	w, err := New(rand.Read, netPrefix, db, GenerateDecoys, GenerateInputs, "pass", wPath)
+	assert.Nil(t, err)
+	return w
+}
+
+// sealBlock links `blk` to `prev`, if given, and fills in its tx root and
+// hash, so that it passes the header checks of the wallet.
+func sealBlock(t *testing.T, blk *block.Block, prev *block.Block) *block.Block {
+	if prev != nil {
+		blk.Header.Height = prev.Header.Height + 1
+		blk.SetPrevBlock(prev.Header)
+	}
+
+	if len(blk.Txs) > 0 {
+		root, err := blk.CalculateRoot()
+		assert.NoError(t, err)
+		blk.Header.TxRoot = root
+	}
+
+	hash, err := blk.CalculateHash()
+	assert.NoError(t, err)
+	blk.Header.Hash = hash
+	return blk
 }
 
 func generateStandardTx(t *testing.T, receiver key.PublicAddress, amount int64, sender *Wallet) *transactions.Standard {
@@ -0,0 +1,507 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dusk-network/dusk-wallet/v2/transactions"
+	"github.com/dusk-network/dusk-wallet/v2/txrecords"
+	"github.com/syndtr/goleveldb/leveldb"
+	"golang.org/x/crypto/sha3"
+)
+
+// Webhook event kinds, sent as the `type` of a notification.
+const (
+	WebhookPaymentReceived  = "payment.received"
+	WebhookPaymentConfirmed = "payment.confirmed"
+	WebhookOutputUnlocked   = "output.unlocked"
+	WebhookStakeUnlocked    = "stake.unlocked"
+)
+
+// SignatureHeader holds the hex encoded HMAC-SHA256 of the request body,
+// keyed with the notifier secret.
+const SignatureHeader = "X-Dusk-Signature"
+
+// EventIDHeader holds the id of the notification, which stays the same
+// across retries.
+const EventIDHeader = "X-Dusk-Event-Id"
+
+// NotifierConfig configures a Notifier.
+type NotifierConfig struct {
+	// Endpoints are the URLs every notification is posted to. They have to
+	// point to the local machine
+	Endpoints []string
+	// Secret is the key the request bodies are signed with
+	Secret []byte
+	// Confirmations is the amount of blocks after which a received payment
+	// is reported as confirmed. 0 disables confirmation notifications
+	Confirmations uint64
+
+	// RetryDelay is the time waited before retrying a failed delivery. It
+	// doubles with every attempt, up to MaxRetryDelay
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// MaxAttempts is the amount of deliveries attempted before a
+	// notification is dropped. 0 retries forever
+	MaxAttempts uint32
+	// Retention is how long finished notifications are remembered, so that
+	// events seen again, for instance after a rescan, are not delivered
+	// twice. Defaults to a week
+	Retention time.Duration
+
+	// Client is used to send the requests. Defaults to a client with a
+	// 10 second timeout
+	Client *http.Client
+}
+
+// Notification is the JSON body posted to the endpoints.
+type Notification struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Height     uint64 `json:"height"`
+	TxID       string `json:"txid"`
+	PubKey     string `json:"pubkey"`
+	Amount     uint64 `json:"amount"`
+	LockReason string `json:"lock_reason,omitempty"`
+}
+
+// outboxEntry is a notification waiting to be delivered to an endpoint.
+type outboxEntry struct {
+	Endpoint string `json:"endpoint"`
+	Body     []byte `json:"body"`
+	// NotBefore is the wallet height from which on the notification is due
+	NotBefore   uint64    `json:"not_before"`
+	Attempts    uint32    `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	// ConfirmsTx is set for confirmation notifications. They are only
+	// delivered while the tx is still recorded in the block at
+	// ConfirmsHeight, as a rollback may have removed it since
+	ConfirmsTx     []byte `json:"confirms_tx,omitempty"`
+	ConfirmsHeight uint64 `json:"confirms_height,omitempty"`
+}
+
+// Notifier posts wallet events to webhook endpoints. Notifications are
+// stored in an outbox in the wallet database before they are sent, so
+// that failed deliveries are retried, even after a restart. The same
+// event is only queued once, but as a delivery can succeed without the
+// notifier learning about it, endpoints should deduplicate on the event id.
+type Notifier struct {
+	w   *Wallet
+	cfg NotifierConfig
+
+	// flush makes sure a notification is not delivered by two flushes at
+	// once. Queueing does not need it, as it never touches existing entries
+	flush  sync.Mutex
+	pruned time.Time
+	wake   chan struct{}
+	now    func() time.Time
+
+	// queueErr is the first error hit while queueing notifications, which
+	// is returned by the next Flush
+	errLock  sync.Mutex
+	queueErr error
+}
+
+// NewNotifier returns a Notifier for the events of `w`.
+func NewNotifier(w *Wallet, cfg NotifierConfig) (*Notifier, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("no webhook endpoints configured")
+	}
+
+	for _, endpoint := range cfg.Endpoints {
+		if err := checkLocalEndpoint(endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.Secret) == 0 {
+		return nil, errors.New("webhook secret can not be empty")
+	}
+
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = time.Second
+	}
+
+	if cfg.MaxRetryDelay == 0 {
+		cfg.MaxRetryDelay = time.Hour
+	}
+
+	if cfg.Retention == 0 {
+		cfg.Retention = 7 * 24 * time.Hour
+	}
+
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Notifier{
+		w:    w,
+		cfg:  cfg,
+		wake: make(chan struct{}, 1),
+		now:  time.Now,
+	}, nil
+}
+
+func checkLocalEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook endpoint %s is not an http url", endpoint)
+	}
+
+	host := u.Hostname()
+	if host == "localhost" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return fmt.Errorf("webhook endpoint %s is not local", endpoint)
+}
+
+// Run subscribes to the wallet events, and delivers the notifications
+// until `ctx` is done. Due deliveries are checked every `interval`, and
+// whenever a new notification is queued.
+func (n *Notifier) Run(ctx context.Context, interval time.Duration) error {
+	unsubscribe := n.w.Subscribe(n.handle)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := n.Flush(ctx); err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-n.wake:
+		}
+	}
+}
+
+// handle queues the notifications for a wallet event.
+func (n *Notifier) handle(e Event) {
+	var err error
+	switch e.Type {
+	case EventReceived:
+		// Change is not a payment
+		if e.Change {
+			return
+		}
+
+		err = n.enqueue(WebhookPaymentReceived, e, 0, false)
+		if err == nil && n.cfg.Confirmations > 0 {
+			err = n.enqueue(WebhookPaymentConfirmed, e, e.Height+n.cfg.Confirmations, true)
+		}
+	case EventUnlocked:
+		kind := WebhookOutputUnlocked
+		if e.LockReason == transactions.LockStake {
+			kind = WebhookStakeUnlocked
+		}
+		err = n.enqueue(kind, e, 0, false)
+	default:
+		return
+	}
+
+	// Events are published after the wallet stored them, so the failure
+	// can not be reported to the wallet. It is returned by the next Flush
+	// instead, and a rescan will publish the event again.
+	if err != nil {
+		n.errLock.Lock()
+		if n.queueErr == nil {
+			n.queueErr = fmt.Errorf("queueing %s notification: %v", e.Type, err)
+		}
+		n.errLock.Unlock()
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// eventID derives a stable id for a notification, so that the same event
+// seen twice, for instance after a rescan, is only delivered once. The
+// height is left out, as a reorg may include the tx again at another one.
+func eventID(kind string, e Event) []byte {
+	h := sha3.New256()
+	h.Write([]byte(kind))
+	h.Write(e.TxID)
+	h.Write(e.PubKey.Bytes())
+	return h.Sum(nil)
+}
+
+// outboxID is the id of the outbox entry of a notification for an endpoint.
+func outboxID(eventID []byte, endpoint string) []byte {
+	h := sha3.Sum256([]byte(endpoint))
+	return append(append([]byte{}, eventID...), h[:8]...)
+}
+
+// enqueue stores the notification of kind `kind` for `e` in the outbox,
+// for every endpoint. The notification is due from wallet height
+// `notBefore` on. If `confirms` is set, it is only delivered if the tx of
+// `e` is still part of the chain by then.
+func (n *Notifier) enqueue(kind string, e Event, notBefore uint64, confirms bool) error {
+	id := eventID(kind, e)
+	notification := Notification{
+		ID:     hex.EncodeToString(id),
+		Type:   kind,
+		Height: e.Height,
+		TxID:   hex.EncodeToString(e.TxID),
+		PubKey: hex.EncodeToString(e.PubKey.Bytes()),
+		Amount: e.Amount,
+	}
+
+	if e.LockReason != transactions.LockNone {
+		notification.LockReason = e.LockReason.String()
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range n.cfg.Endpoints {
+		key := outboxID(id, endpoint)
+		if confirms {
+			moved, err := n.moveConfirmation(key, body, notBefore, e.Height)
+			if err != nil {
+				return err
+			}
+
+			if moved {
+				continue
+			}
+		}
+
+		known, err := n.w.db.HasOutboxEntry(key)
+		if err != nil {
+			return err
+		}
+
+		if known {
+			// Queued or delivered before
+			continue
+		}
+
+		entry := &outboxEntry{
+			Endpoint:  endpoint,
+			Body:      body,
+			NotBefore: notBefore,
+		}
+
+		if confirms {
+			entry.ConfirmsTx = e.TxID
+			entry.ConfirmsHeight = e.Height
+		}
+
+		if err := n.putEntry(key, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveConfirmation moves the queued confirmation with id `key` to the tx
+// included at `height`, if a reorg included it at another height since the
+// confirmation was queued. It returns false if no confirmation is queued.
+func (n *Notifier) moveConfirmation(key, body []byte, notBefore, height uint64) (bool, error) {
+	value, err := n.w.db.GetOutboxEntry(key)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	entry := &outboxEntry{}
+	if err := json.Unmarshal(value, entry); err != nil {
+		return false, err
+	}
+
+	if entry.ConfirmsHeight == height {
+		return true, nil
+	}
+
+	entry.Body = body
+	entry.NotBefore = notBefore
+	entry.ConfirmsHeight = height
+	return true, n.putEntry(key, entry)
+}
+
+func (n *Notifier) putEntry(key []byte, entry *outboxEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return n.w.db.PutOutboxEntry(key, value)
+}
+
+// Flush attempts to deliver all notifications which are due. It returns
+// the first error hit while queueing notifications since the last Flush.
+func (n *Notifier) Flush(ctx context.Context) error {
+	n.flush.Lock()
+	defer n.flush.Unlock()
+
+	n.errLock.Lock()
+	err := n.queueErr
+	n.queueErr = nil
+	n.errLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	height, err := n.w.GetSavedHeight()
+	if err != nil {
+		return err
+	}
+
+	// Finished notifications are forgotten once they are older than the
+	// retention window, which is checked about once an hour
+	if n.now().Sub(n.pruned) >= time.Hour {
+		if err := n.w.db.PruneFinishedOutboxEntries(n.now().Add(-n.cfg.Retention).Unix()); err != nil {
+			return err
+		}
+		n.pruned = n.now()
+	}
+
+	entries, err := n.w.db.FetchOutboxEntries()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry := &outboxEntry{}
+		if err := json.Unmarshal(e.Value, entry); err != nil {
+			return err
+		}
+
+		if entry.NotBefore > height || n.now().Before(entry.NextAttempt) {
+			continue
+		}
+
+		if entry.ConfirmsTx != nil {
+			confirmed, err := n.stillConfirmed(entry)
+			if err != nil {
+				return err
+			}
+
+			if !confirmed {
+				// The tx was rolled back. If it is included again, a new
+				// confirmation is queued for it
+				if err := n.w.db.DeleteOutboxEntry(e.ID); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		entry.Attempts++
+		err := n.deliver(ctx, entry)
+		if err == nil || (n.cfg.MaxAttempts != 0 && entry.Attempts >= n.cfg.MaxAttempts) {
+			if err := n.w.db.FinishOutboxEntry(e.ID, n.now().Unix()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry.NextAttempt = n.now().Add(n.backoff(entry.Attempts))
+		if err := n.putEntry(e.ID, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stillConfirmed returns true if the tx confirmed by `entry` is still
+// recorded in the block it was received in.
+func (n *Notifier) stillConfirmed(entry *outboxEntry) (bool, error) {
+	txRecord, err := n.w.db.GetTxRecord(entry.ConfirmsTx)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return txRecord.State == txrecords.Confirmed && txRecord.Height == entry.ConfirmsHeight, nil
+}
+
+// backoff returns the delay before the next delivery attempt, after
+// `attempts` failed ones.
+func (n *Notifier) backoff(attempts uint32) time.Duration {
+	delay := n.cfg.RetryDelay
+	for i := uint32(1); i < attempts && delay < n.cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > n.cfg.MaxRetryDelay {
+		delay = n.cfg.MaxRetryDelay
+	}
+
+	return delay
+}
+
+// SignWebhookBody returns the signature of a request body, as sent in the
+// SignatureHeader.
+func SignWebhookBody(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) deliver(ctx context.Context, entry *outboxEntry) error {
+	req, err := http.NewRequest(http.MethodPost, entry.Endpoint, bytes.NewReader(entry.Body))
+	if err != nil {
+		return err
+	}
+
+	var notification Notification
+	if err := json.Unmarshal(entry.Body, &notification); err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, SignWebhookBody(n.cfg.Secret, entry.Body))
+	req.Header.Set(EventIDHeader, notification.ID)
+
+	resp, err := n.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint %s returned %s", entry.Endpoint, resp.Status)
+	}
+
+	return nil
+}